@@ -0,0 +1,239 @@
+package bgp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// ASTrans is the reserved 2-octet AS number used in the OPEN message
+// and in 2-octet AS_PATH/AGGREGATOR attributes whenever the real AS
+// number doesn't fit in two octets. See RFC 6793, section 4.1.
+const ASTrans uint16 = 23456
+
+// Capability code for the 4-octet AS Number capability. See RFC 6793,
+// section 4.1.
+const CapASN4 uint8 = 65
+
+// NewASNCapabilityParameter builds an OPEN optional Parameter
+// advertising the 4-octet AS Number capability (code 65) for asn.
+func NewASNCapabilityParameter(asn uint32) Parameter {
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, asn)
+	return Parameter{Type: paramCapability, Value: append([]byte{CapASN4, 4}, value...)}
+}
+
+// ASNCapability scans params for a 4-octet AS Number capability and
+// returns the advertised ASN, if any.
+func ASNCapability(params []Parameter) (uint32, bool) {
+	for _, param := range params {
+		if param.Type != paramCapability {
+			continue
+		}
+		b := param.Value
+		for off := 0; off+2 <= len(b); {
+			code := b[off]
+			l := int(b[off+1])
+			off += 2
+			if off+l > len(b) {
+				break
+			}
+			if code == CapASN4 && l == 4 {
+				return binary.BigEndian.Uint32(b[off : off+l]), true
+			}
+			off += l
+		}
+	}
+	return 0, false
+}
+
+// AS_PATH segment types. See RFC 4271, section 4.3.
+const (
+	ASSet      uint8 = 1
+	ASSequence uint8 = 2
+)
+
+// ASPathSegment is one segment of an AS_PATH or AS4_PATH attribute.
+type ASPathSegment struct {
+	Type uint8
+	ASNs []uint32
+}
+
+// ASPath is a full AS_PATH or AS4_PATH attribute, decoded into typed
+// segments so callers don't manipulate the raw attribute bytes.
+type ASPath []ASPathSegment
+
+// flatten returns every ASN across every segment, in order, ignoring
+// segment (AS_SET vs AS_SEQUENCE) boundaries.
+func (a ASPath) flatten() []uint32 {
+	var out []uint32
+	for _, seg := range a {
+		out = append(out, seg.ASNs...)
+	}
+	return out
+}
+
+// marshal encodes a as a Path attribute value, using width bytes (2 or
+// 4) per ASN. A 2-octet encoding substitutes ASTrans for any ASN that
+// doesn't fit, per RFC 6793 section 4.2.2.
+func (a ASPath) marshal(width int) []byte {
+	var b []byte
+	for _, seg := range a {
+		b = append(b, seg.Type, uint8(len(seg.ASNs)))
+		for _, asn := range seg.ASNs {
+			asBuf := make([]byte, width)
+			if width == 2 {
+				binary.BigEndian.PutUint16(asBuf, twoOctetAS(asn))
+			} else {
+				binary.BigEndian.PutUint32(asBuf, asn)
+			}
+			b = append(b, asBuf...)
+		}
+	}
+	return b
+}
+
+func unmarshalASPath(b []byte, width int) (ASPath, error) {
+	var path ASPath
+	for off := 0; off < len(b); {
+		if off+2 > len(b) {
+			return nil, fmt.Errorf("bgp: short AS_PATH segment header")
+		}
+		seg := ASPathSegment{Type: b[off]}
+		count := int(b[off+1])
+		off += 2
+		for i := 0; i < count; i++ {
+			if off+width > len(b) {
+				return nil, fmt.Errorf("bgp: truncated AS_PATH segment")
+			}
+			var asn uint32
+			if width == 2 {
+				asn = uint32(binary.BigEndian.Uint16(b[off : off+width]))
+			} else {
+				asn = binary.BigEndian.Uint32(b[off : off+width])
+			}
+			seg.ASNs = append(seg.ASNs, asn)
+			off += width
+		}
+		path = append(path, seg)
+	}
+	return path, nil
+}
+
+// NewASPath2Octet builds the classic 2-octet AS_PATH Path attribute.
+// ASNs that don't fit in two octets are replaced with ASTrans; pair
+// this with NewAS4Path to preserve their real values for peers that
+// understand it.
+func NewASPath2Octet(path ASPath) Path {
+	return Path{Flags: FlagTransitive, Code: CodeASPath, Value: path.marshal(2)}
+}
+
+// NewASPath4Octet builds an AS_PATH Path attribute using full 4-octet
+// ASNs, for peers that have negotiated the 4-octet AS Number
+// capability.
+func NewASPath4Octet(path ASPath) Path {
+	return Path{Flags: FlagTransitive, Code: CodeASPath, Value: path.marshal(4)}
+}
+
+// NewAS4Path builds the AS4_PATH optional transitive attribute: the
+// real 4-octet AS path, carried alongside a 2-octet AS_PATH sent to a
+// peer without 4-octet AS Number support. See RFC 6793, section 4.2.2.
+func NewAS4Path(path ASPath) Path {
+	return Path{Flags: FlagOptional | FlagTransitive, Code: CodeAS4Path, Value: path.marshal(4)}
+}
+
+// DecodeASPath decodes an AS_PATH (or AS4_PATH) Path attribute.
+// fourOctet must reflect whether the sending peer negotiated the
+// 4-octet AS Number capability (for AS_PATH) or be true for AS4_PATH,
+// since the attribute doesn't self-describe its ASN width.
+func DecodeASPath(p Path, fourOctet bool) (ASPath, error) {
+	if p.Code != CodeASPath && p.Code != CodeAS4Path {
+		return nil, fmt.Errorf("bgp: path code %d is not AS_PATH/AS4_PATH", p.Code)
+	}
+	width := 2
+	if fourOctet {
+		width = 4
+	}
+	return unmarshalASPath(p.Value, width)
+}
+
+// ReconcileASPath implements the AS4_PATH attribute reconstruction
+// procedure from RFC 6793, section 4.2.3: if as4Path has more ASNs than
+// asPath, as4Path is malformed relative to it and is ignored; otherwise
+// the trailing ASNs of asPath are replaced with as4Path's, preserving
+// asPath's original segment boundaries.
+func ReconcileASPath(asPath, as4Path ASPath) ASPath {
+	if len(as4Path) == 0 {
+		return asPath
+	}
+	oldFlat := asPath.flatten()
+	newFlat := as4Path.flatten()
+	if len(newFlat) > len(oldFlat) {
+		return asPath
+	}
+
+	merged := append([]uint32(nil), oldFlat...)
+	copy(merged[len(merged)-len(newFlat):], newFlat)
+
+	out := make(ASPath, len(asPath))
+	idx := 0
+	for i, seg := range asPath {
+		out[i] = ASPathSegment{Type: seg.Type, ASNs: append([]uint32(nil), merged[idx:idx+len(seg.ASNs)]...)}
+		idx += len(seg.ASNs)
+	}
+	return out
+}
+
+// Aggregator is the decoded form of an AGGREGATOR or AS4_AGGREGATOR
+// path attribute (RFC 4271 section 5.1.7; RFC 6793 section 4.2.3).
+type Aggregator struct {
+	ASN     uint32
+	Address net.IP
+}
+
+// NewAggregator builds the classic 2-octet AGGREGATOR attribute,
+// substituting ASTrans if asn doesn't fit in two octets.
+func NewAggregator(asn uint32, address net.IP) Path {
+	b := make([]byte, 6)
+	binary.BigEndian.PutUint16(b[0:2], twoOctetAS(asn))
+	copy(b[2:6], address.To4())
+	return Path{Flags: FlagOptional | FlagTransitive, Code: CodeAggregator, Value: b}
+}
+
+// NewAS4Aggregator builds the AS4_AGGREGATOR attribute carrying the
+// real 4-octet ASN, to accompany an AGGREGATOR sent to a peer without
+// 4-octet AS Number support.
+func NewAS4Aggregator(asn uint32, address net.IP) Path {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b[0:4], asn)
+	copy(b[4:8], address.To4())
+	return Path{Flags: FlagOptional | FlagTransitive, Code: CodeAS4Aggregator, Value: b}
+}
+
+// DecodeAggregator decodes an AGGREGATOR or AS4_AGGREGATOR attribute.
+func DecodeAggregator(p Path) (*Aggregator, error) {
+	switch p.Code {
+	case CodeAggregator:
+		if len(p.Value) != 6 {
+			return nil, fmt.Errorf("bgp: malformed AGGREGATOR")
+		}
+		return &Aggregator{ASN: uint32(binary.BigEndian.Uint16(p.Value[0:2])), Address: append(net.IP(nil), p.Value[2:6]...)}, nil
+	case CodeAS4Aggregator:
+		if len(p.Value) != 8 {
+			return nil, fmt.Errorf("bgp: malformed AS4_AGGREGATOR")
+		}
+		return &Aggregator{ASN: binary.BigEndian.Uint32(p.Value[0:4]), Address: append(net.IP(nil), p.Value[4:8]...)}, nil
+	}
+	return nil, fmt.Errorf("bgp: path code %d is not AGGREGATOR/AS4_AGGREGATOR", p.Code)
+}
+
+// ReconcileAggregator implements the AS4_AGGREGATOR reconstruction rule
+// from RFC 6793, section 4.2.3: if the 2-octet AGGREGATOR carries
+// ASTrans and an AS4_AGGREGATOR was received, the latter holds the
+// real ASN and is used instead.
+func ReconcileAggregator(agg, as4Agg *Aggregator) *Aggregator {
+	if agg != nil && agg.ASN == uint32(ASTrans) && as4Agg != nil {
+		return as4Agg
+	}
+	return agg
+}
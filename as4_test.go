@@ -0,0 +1,109 @@
+package bgp
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestOPENEncodesASTrans(t *testing.T) {
+	open := NewOPEN(4200000000, 90, net.ParseIP("1.1.1.1"), nil)
+	b, err := Marshal(open)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var got OPEN
+	if _, err := got.unpack(b[headerLen:]); err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+	if got.MyAS != 4200000000 {
+		t.Fatalf("got MyAS %d, want 4200000000 (recovered via capability 65)", got.MyAS)
+	}
+}
+
+func TestOPENSmallASDoesNotNeedASTrans(t *testing.T) {
+	open := NewOPEN(65001, 90, net.ParseIP("1.1.1.1"), nil)
+	b, err := Marshal(open)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if got := uint16(b[headerLen+1])<<8 | uint16(b[headerLen+2]); got != 65001 {
+		t.Fatalf("2-octet AS field = %d, want 65001", got)
+	}
+}
+
+func TestASPathRoundTrip4Octet(t *testing.T) {
+	path := ASPath{{Type: ASSequence, ASNs: []uint32{65001, 4200000000, 65003}}}
+	p := NewASPath4Octet(path)
+
+	got, err := DecodeASPath(p, true)
+	if err != nil {
+		t.Fatalf("DecodeASPath: %v", err)
+	}
+	if !reflect.DeepEqual(got, path) {
+		t.Fatalf("got %+v, want %+v", got, path)
+	}
+}
+
+func TestASPath2OctetSubstitutesASTrans(t *testing.T) {
+	path := ASPath{{Type: ASSequence, ASNs: []uint32{65001, 4200000000}}}
+	p := NewASPath2Octet(path)
+
+	got, err := DecodeASPath(p, false)
+	if err != nil {
+		t.Fatalf("DecodeASPath: %v", err)
+	}
+	want := ASPath{{Type: ASSequence, ASNs: []uint32{65001, uint32(ASTrans)}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestReconcileASPath(t *testing.T) {
+	asPath := ASPath{
+		{Type: ASSequence, ASNs: []uint32{65001, uint32(ASTrans), uint32(ASTrans)}},
+	}
+	as4Path := ASPath{
+		{Type: ASSequence, ASNs: []uint32{4200000001, 4200000002}},
+	}
+
+	got := ReconcileASPath(asPath, as4Path)
+	want := ASPath{{Type: ASSequence, ASNs: []uint32{65001, 4200000001, 4200000002}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestReconcileASPathIgnoresOversizedAS4Path(t *testing.T) {
+	asPath := ASPath{{Type: ASSequence, ASNs: []uint32{65001}}}
+	as4Path := ASPath{{Type: ASSequence, ASNs: []uint32{1, 2, 3}}}
+
+	got := ReconcileASPath(asPath, as4Path)
+	if !reflect.DeepEqual(got, asPath) {
+		t.Fatalf("got %+v, want asPath unchanged: %+v", got, asPath)
+	}
+}
+
+func TestAggregatorRoundTrip(t *testing.T) {
+	addr := net.ParseIP("192.0.2.1")
+	p := NewAggregator(65001, addr)
+	got, err := DecodeAggregator(p)
+	if err != nil {
+		t.Fatalf("DecodeAggregator: %v", err)
+	}
+	if got.ASN != 65001 || !got.Address.Equal(addr) {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestReconcileAggregatorUsesAS4WhenASTransPresent(t *testing.T) {
+	addr := net.ParseIP("192.0.2.1")
+	agg, _ := DecodeAggregator(NewAggregator(uint32(ASTrans), addr))
+	as4Agg, _ := DecodeAggregator(NewAS4Aggregator(4200000000, addr))
+
+	got := ReconcileAggregator(agg, as4Agg)
+	if got.ASN != 4200000000 {
+		t.Fatalf("got ASN %d, want 4200000000", got.ASN)
+	}
+}
@@ -0,0 +1,310 @@
+// Package bmp implements the BGP Monitoring Protocol (RFC 7854): the
+// common header, the Per-Peer Header, and a Client that mirrors BGP
+// session activity to a single BMP collector over TCP. A bgp.Session
+// holds one attached sink at a time; a second AttachBMP call replaces
+// the first.
+//
+// bmp has no dependency on the bgp package's types: it accepts raw,
+// already-packed BGP message bytes (as produced by bgp.Marshal) and
+// plain net.IP/uint32 peer identifiers, so a *Client satisfies
+// bgp.Session's BMPSink interface without introducing an import cycle.
+package bmp
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// Version is the BMP version this package implements.
+const Version uint8 = 3
+
+// Message types. See RFC 7854, section 4.
+const (
+	MsgRouteMonitoring      uint8 = 0
+	MsgStatisticsReport     uint8 = 1
+	MsgPeerDownNotification uint8 = 2
+	MsgPeerUpNotification   uint8 = 3
+	MsgInitiation           uint8 = 4
+	MsgTermination          uint8 = 5
+)
+
+// Initiation/Termination Information TLV types. See RFC 7854,
+// sections 4.3 and 4.5.
+const (
+	TLVString     uint16 = 0
+	TLVSysDescr   uint16 = 1
+	TLVSysName    uint16 = 2
+	TLVTermReason uint16 = 1
+)
+
+// PerPeerHeader precedes the payload of every Route Monitoring,
+// Statistics Report, Peer Down and Peer Up message. See RFC 7854,
+// section 4.2.
+type PerPeerHeader struct {
+	PeerType          uint8
+	PeerFlags         uint8
+	PeerDistinguisher uint64
+	PeerAddress       net.IP
+	PeerAS            uint32
+	PeerBGPID         net.IP
+	Timestamp         time.Time
+}
+
+func (h *PerPeerHeader) marshal() []byte {
+	b := make([]byte, 42)
+	b[0] = h.PeerType
+	b[1] = h.PeerFlags
+	binary.BigEndian.PutUint64(b[2:10], h.PeerDistinguisher)
+	copy(b[10:26], h.PeerAddress.To16())
+	binary.BigEndian.PutUint32(b[26:30], h.PeerAS)
+	copy(b[30:34], h.PeerBGPID.To4())
+	binary.BigEndian.PutUint32(b[34:38], uint32(h.Timestamp.Unix()))
+	binary.BigEndian.PutUint32(b[38:42], uint32(h.Timestamp.Nanosecond()/1000))
+	return b
+}
+
+// packMessage wraps payload in the 6-byte BMP common header: version,
+// total message length (including this header), and message type.
+func packMessage(typ uint8, payload []byte) []byte {
+	b := make([]byte, 6+len(payload))
+	b[0] = Version
+	binary.BigEndian.PutUint32(b[1:5], uint32(len(b)))
+	b[5] = typ
+	copy(b[6:], payload)
+	return b
+}
+
+func appendTLV(b []byte, typ uint16, value []byte) []byte {
+	tlv := make([]byte, 4+len(value))
+	binary.BigEndian.PutUint16(tlv[0:2], typ)
+	binary.BigEndian.PutUint16(tlv[2:4], uint16(len(value)))
+	copy(tlv[4:], value)
+	return append(b, tlv...)
+}
+
+// StatsInterval is how often Start emits an automatic, empty
+// Statistics Report for each peer currently registered via PeerUp.
+const StatsInterval = time.Minute
+
+// peerKey identifies a peer tracked by Client for periodic statistics,
+// keyed on the values PeerUp/PeerDown are called with.
+type peerKey struct {
+	addr  string
+	bgpID string
+}
+
+type peerInfo struct {
+	addr  net.IP
+	as    uint32
+	bgpID net.IP
+}
+
+// Client mirrors BGP activity to a single BMP collector. It buffers
+// outgoing messages and reconnects with exponential backoff when the
+// collector connection drops.
+type Client struct {
+	addr              string
+	sysName, sysDescr string
+
+	queue chan []byte
+	done  chan struct{}
+
+	mu    sync.Mutex
+	conn  net.Conn
+	peers map[peerKey]peerInfo
+}
+
+// NewClient returns a Client that will connect to collectorAddr and
+// identify itself with the given sysName/sysDescr in its Initiation
+// message (RFC 7854, section 4.3). Call Start to begin connecting.
+func NewClient(collectorAddr, sysName, sysDescr string) *Client {
+	return &Client{
+		addr:     collectorAddr,
+		sysName:  sysName,
+		sysDescr: sysDescr,
+		queue:    make(chan []byte, 256),
+		done:     make(chan struct{}),
+		peers:    make(map[peerKey]peerInfo),
+	}
+}
+
+// Start launches the connection-management goroutine and the periodic
+// statistics ticker. It returns immediately; connection failures are
+// retried in the background.
+func (c *Client) Start() {
+	go c.run()
+	go c.statsLoop()
+}
+
+// statsLoop emits an empty Statistics Report (RFC 7854, section 4.6)
+// for every peer currently registered via PeerUp, once per
+// StatsInterval, until the Client is closed.
+func (c *Client) statsLoop() {
+	t := time.NewTicker(StatsInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			c.mu.Lock()
+			peers := make([]peerInfo, 0, len(c.peers))
+			for _, p := range c.peers {
+				peers = append(peers, p)
+			}
+			c.mu.Unlock()
+			for _, p := range peers {
+				c.StatisticsReport(p.addr, p.as, p.bgpID, 0, nil)
+			}
+		}
+	}
+}
+
+// Close stops the Client, sending a best-effort Termination message
+// (RFC 7854, section 4.5, reason "Administratively Closed") before
+// closing any open collector connection.
+func (c *Client) Close() error {
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		reason := make([]byte, 2)
+		conn.Write(packMessage(MsgTermination, appendTLV(nil, TLVTermReason, reason)))
+	}
+
+	close(c.done)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+func (c *Client) run() {
+	backoff := time.Second
+	for {
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+
+		conn, err := net.Dial("tcp", c.addr)
+		if err != nil {
+			select {
+			case <-c.done:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > time.Minute {
+				backoff = time.Minute
+			}
+			continue
+		}
+		backoff = time.Second
+
+		c.mu.Lock()
+		c.conn = conn
+		c.mu.Unlock()
+
+		initiation := appendTLV(appendTLV(nil, TLVSysDescr, []byte(c.sysDescr)), TLVSysName, []byte(c.sysName))
+		c.enqueue(packMessage(MsgInitiation, initiation))
+
+		c.drain(conn)
+	}
+}
+
+// drain writes queued messages to conn until it fails or the Client is
+// closed, at which point run dials again.
+func (c *Client) drain(conn net.Conn) {
+	for {
+		select {
+		case <-c.done:
+			conn.Close()
+			return
+		case msg := <-c.queue:
+			if _, err := conn.Write(msg); err != nil {
+				c.mu.Lock()
+				c.conn = nil
+				c.mu.Unlock()
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// enqueue buffers msg, dropping it if the queue is full rather than
+// blocking the caller (typically a Session's hot path).
+func (c *Client) enqueue(msg []byte) {
+	select {
+	case c.queue <- msg:
+	default:
+	}
+}
+
+// RouteMonitoring sends a Route Monitoring message mirroring a single
+// received BGP UPDATE. raw must be the complete, already-marked BGP
+// message (e.g. from bgp.Marshal) so it can be forwarded without
+// re-encoding.
+func (c *Client) RouteMonitoring(peerAddress net.IP, peerAS uint32, peerBGPID net.IP, raw []byte) {
+	h := PerPeerHeader{PeerAddress: peerAddress, PeerAS: peerAS, PeerBGPID: peerBGPID, Timestamp: time.Now()}
+	c.enqueue(packMessage(MsgRouteMonitoring, append(h.marshal(), raw...)))
+}
+
+// PeerUp sends a Peer Up Notification for a session that just reached
+// Established. sentOpen and recvOpen are the raw OPEN messages
+// exchanged during the handshake, required by RFC 7854 section 4.10.
+// It also records the peer as active so ticker-driven statistics
+// reports (see Start) cover it.
+func (c *Client) PeerUp(peerAddress net.IP, peerAS uint32, peerBGPID net.IP, sentOpen, recvOpen []byte) {
+	h := PerPeerHeader{PeerAddress: peerAddress, PeerAS: peerAS, PeerBGPID: peerBGPID, Timestamp: time.Now()}
+	// Local address/port are not tracked by bgp.Session today; the
+	// payload is the Per-Peer Header, a 16-byte zero local address and
+	// zero ports (which RFC 7854 permits a monitoring station to treat
+	// as "unknown"), followed by the sent and received OPEN messages.
+	payload := append(h.marshal(), make([]byte, 20)...)
+	payload = append(payload, sentOpen...)
+	payload = append(payload, recvOpen...)
+	c.enqueue(packMessage(MsgPeerUpNotification, payload))
+
+	c.mu.Lock()
+	c.peers[peerKey{peerAddress.String(), peerBGPID.String()}] = peerInfo{peerAddress, peerAS, peerBGPID}
+	c.mu.Unlock()
+}
+
+// PeerDown sends a Peer Down Notification for a session that just left
+// Established. reason is the RFC 7854 section 4.9 reason code; 0 means
+// unspecified/local close.
+func (c *Client) PeerDown(peerAddress net.IP, peerAS uint32, peerBGPID net.IP, reason uint8) {
+	h := PerPeerHeader{PeerAddress: peerAddress, PeerAS: peerAS, PeerBGPID: peerBGPID, Timestamp: time.Now()}
+	payload := append(h.marshal(), reason)
+	c.enqueue(packMessage(MsgPeerDownNotification, payload))
+
+	c.mu.Lock()
+	delete(c.peers, peerKey{peerAddress.String(), peerBGPID.String()})
+	c.mu.Unlock()
+}
+
+// StatisticsReport sends a Statistics Report message for a peer (RFC
+// 7854, section 4.6). stats is the already-encoded sequence of
+// Statistics Type TLVs; count is how many TLVs it contains. A nil
+// stats with count 0 sends a report carrying no counters, which is
+// valid and simply tells the collector the peer is still up.
+func (c *Client) StatisticsReport(peerAddress net.IP, peerAS uint32, peerBGPID net.IP, count uint32, stats []byte) {
+	h := PerPeerHeader{PeerAddress: peerAddress, PeerAS: peerAS, PeerBGPID: peerBGPID, Timestamp: time.Now()}
+	payload := append(h.marshal(), make([]byte, 4)...)
+	binary.BigEndian.PutUint32(payload[len(payload)-4:], count)
+	payload = append(payload, stats...)
+	c.enqueue(packMessage(MsgStatisticsReport, payload))
+}
@@ -0,0 +1,137 @@
+package bmp
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPerPeerHeaderMarshalLength(t *testing.T) {
+	h := PerPeerHeader{
+		PeerAddress: net.ParseIP("192.0.2.1"),
+		PeerAS:      65001,
+		PeerBGPID:   net.ParseIP("192.0.2.2"),
+		Timestamp:   time.Unix(1000, 0),
+	}
+	b := h.marshal()
+	if len(b) != 42 {
+		t.Fatalf("got %d bytes, want 42", len(b))
+	}
+	if got := binary.BigEndian.Uint32(b[26:30]); got != 65001 {
+		t.Errorf("PeerAS = %d, want 65001", got)
+	}
+	if got := binary.BigEndian.Uint32(b[34:38]); got != 1000 {
+		t.Errorf("Timestamp seconds = %d, want 1000", got)
+	}
+}
+
+func TestPackMessageHeader(t *testing.T) {
+	b := packMessage(MsgRouteMonitoring, []byte{1, 2, 3})
+	if b[0] != Version {
+		t.Errorf("version = %d, want %d", b[0], Version)
+	}
+	if got := binary.BigEndian.Uint32(b[1:5]); got != uint32(len(b)) {
+		t.Errorf("length field = %d, want %d", got, len(b))
+	}
+	if b[5] != MsgRouteMonitoring {
+		t.Errorf("type = %d, want %d", b[5], MsgRouteMonitoring)
+	}
+}
+
+func TestClientEnqueueDoesNotBlockWhenFull(t *testing.T) {
+	c := NewClient("127.0.0.1:0", "test", "test system")
+	for i := 0; i < cap(c.queue)+10; i++ {
+		c.RouteMonitoring(net.ParseIP("192.0.2.1"), 65001, net.ParseIP("192.0.2.2"), []byte{0})
+	}
+	if len(c.queue) != cap(c.queue) {
+		t.Fatalf("queue len = %d, want %d (full, further entries dropped)", len(c.queue), cap(c.queue))
+	}
+}
+
+// TestPeerUpIncludesOpenMessages checks that the Peer Up payload
+// carries the sent/received OPEN messages required by RFC 7854,
+// section 4.10, in addition to the Per-Peer Header and local
+// address/port fields.
+func TestPeerUpIncludesOpenMessages(t *testing.T) {
+	c := NewClient("127.0.0.1:0", "test", "test system")
+	sentOpen := []byte{1, 2, 3}
+	recvOpen := []byte{4, 5, 6, 7}
+	c.PeerUp(net.ParseIP("192.0.2.1"), 65001, net.ParseIP("192.0.2.2"), sentOpen, recvOpen)
+
+	msg := <-c.queue
+	if msg[5] != MsgPeerUpNotification {
+		t.Fatalf("type = %d, want %d", msg[5], MsgPeerUpNotification)
+	}
+	payload := msg[6:]
+	tail := payload[42+20:]
+	if string(tail[:len(sentOpen)]) != string(sentOpen) {
+		t.Errorf("sent OPEN not found in payload")
+	}
+	if string(tail[len(sentOpen):]) != string(recvOpen) {
+		t.Errorf("received OPEN not found in payload")
+	}
+
+	if _, ok := c.peers[peerKey{"192.0.2.1", "192.0.2.2"}]; !ok {
+		t.Errorf("PeerUp did not register the peer for statistics")
+	}
+}
+
+// TestStatisticsReportEncodesCount checks the Statistics Report
+// message carries a 4-byte stat count right after the Per-Peer
+// Header.
+func TestStatisticsReportEncodesCount(t *testing.T) {
+	c := NewClient("127.0.0.1:0", "test", "test system")
+	c.StatisticsReport(net.ParseIP("192.0.2.1"), 65001, net.ParseIP("192.0.2.2"), 0, nil)
+
+	msg := <-c.queue
+	if msg[5] != MsgStatisticsReport {
+		t.Fatalf("type = %d, want %d", msg[5], MsgStatisticsReport)
+	}
+	count := binary.BigEndian.Uint32(msg[6+42 : 6+46])
+	if count != 0 {
+		t.Errorf("stat count = %d, want 0", count)
+	}
+}
+
+// TestCloseSendsTermination checks that Close writes a
+// Termination message to the collector connection before tearing it
+// down.
+func TestCloseSendsTermination(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	clientSide, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	serverSide := <-accepted
+	defer serverSide.Close()
+
+	c := NewClient(ln.Addr().String(), "test", "test system")
+	c.conn = clientSide
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	buf := make([]byte, 6)
+	serverSide.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(serverSide, buf); err != nil {
+		t.Fatalf("reading termination message: %v", err)
+	}
+	if buf[5] != MsgTermination {
+		t.Fatalf("type = %d, want %d", buf[5], MsgTermination)
+	}
+}
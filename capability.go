@@ -0,0 +1,143 @@
+package bgp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// CapabilityCodec encodes and decodes the value portion of one OPEN
+// capability TLV (RFC 5492, section 4). Decode returns an
+// implementation-defined value (typically a small struct specific to
+// the capability); Encode is its inverse.
+type CapabilityCodec interface {
+	Decode(value []byte) (interface{}, error)
+	Encode(v interface{}) ([]byte, error)
+}
+
+// capabilityRegistry maps a capability code to the codec that
+// understands its value. Built-in codecs are registered in init; a
+// caller can add support for additional capabilities with
+// RegisterCapability.
+var capabilityRegistry = map[uint8]CapabilityCodec{}
+
+// RegisterCapability makes codec available for capability code in
+// DecodeCapability/EncodeCapability. Registering a code a second time
+// replaces the previous codec.
+func RegisterCapability(code uint8, codec CapabilityCodec) {
+	capabilityRegistry[code] = codec
+}
+
+func init() {
+	RegisterCapability(CapMultiprotocol, multiprotocolCodec{})
+	RegisterCapability(CapRouteRefresh, routeRefreshCodec{})
+	RegisterCapability(CapASN4, asn4Codec{})
+	RegisterCapability(CapGracefulRestart, gracefulRestartCodec{})
+}
+
+// Capability is one decoded capability TLV from an OPEN's Parameters.
+type Capability struct {
+	Code  uint8
+	Value interface{} // the type produced by that code's CapabilityCodec
+}
+
+// DecodeCapabilities walks every Capabilities optional Parameter (RFC
+// 5492) in params and decodes each TLV using the registered codec for
+// its code. A capability code with no registered codec is skipped.
+func DecodeCapabilities(params []Parameter) ([]Capability, error) {
+	var out []Capability
+	for _, param := range params {
+		if param.Type != paramCapability {
+			continue
+		}
+		b := param.Value
+		for off := 0; off+2 <= len(b); {
+			code := b[off]
+			l := int(b[off+1])
+			off += 2
+			if off+l > len(b) {
+				return nil, fmt.Errorf("bgp: truncated capability value for code %d", code)
+			}
+			codec, ok := capabilityRegistry[code]
+			if ok {
+				v, err := codec.Decode(b[off : off+l])
+				if err != nil {
+					return nil, fmt.Errorf("bgp: decoding capability %d: %w", code, err)
+				}
+				out = append(out, Capability{Code: code, Value: v})
+			}
+			off += l
+		}
+	}
+	return out, nil
+}
+
+// EncodeCapability builds a single-TLV Capabilities optional Parameter
+// for code, using its registered codec to encode v.
+func EncodeCapability(code uint8, v interface{}) (Parameter, error) {
+	codec, ok := capabilityRegistry[code]
+	if !ok {
+		return Parameter{}, fmt.Errorf("bgp: no codec registered for capability %d", code)
+	}
+	value, err := codec.Encode(v)
+	if err != nil {
+		return Parameter{}, err
+	}
+	return Parameter{Type: paramCapability, Value: append([]byte{code, uint8(len(value))}, value...)}, nil
+}
+
+// Route Refresh capability (RFC 2918). Its value is always empty.
+const CapRouteRefresh uint8 = 2
+
+type routeRefreshCodec struct{}
+
+func (routeRefreshCodec) Decode(value []byte) (interface{}, error) {
+	if len(value) != 0 {
+		return nil, fmt.Errorf("bgp: Route Refresh capability must be empty")
+	}
+	return struct{}{}, nil
+}
+
+func (routeRefreshCodec) Encode(interface{}) ([]byte, error) { return nil, nil }
+
+// multiprotocolCodec adapts AFISAFI to the registry, reusing the
+// wire format already used by NewMultiprotocolParameter.
+type multiprotocolCodec struct{}
+
+func (multiprotocolCodec) Decode(value []byte) (interface{}, error) {
+	if len(value) != 4 {
+		return nil, fmt.Errorf("bgp: malformed multiprotocol capability")
+	}
+	return AFISAFI{AFI: binary.BigEndian.Uint16(value[0:2]), SAFI: value[3]}, nil
+}
+
+func (multiprotocolCodec) Encode(v interface{}) ([]byte, error) {
+	pair, ok := v.(AFISAFI)
+	if !ok {
+		return nil, fmt.Errorf("bgp: multiprotocol capability needs an AFISAFI")
+	}
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint16(b[0:2], pair.AFI)
+	b[3] = pair.SAFI
+	return b, nil
+}
+
+// asn4Codec adapts the 4-octet ASN capability to the registry, reusing
+// the wire format already used by NewASNCapabilityParameter.
+type asn4Codec struct{}
+
+func (asn4Codec) Decode(value []byte) (interface{}, error) {
+	if len(value) != 4 {
+		return nil, fmt.Errorf("bgp: malformed 4-octet ASN capability")
+	}
+	return binary.BigEndian.Uint32(value), nil
+}
+
+func (asn4Codec) Encode(v interface{}) ([]byte, error) {
+	asn, ok := v.(uint32)
+	if !ok {
+		return nil, fmt.Errorf("bgp: 4-octet ASN capability needs a uint32")
+	}
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, asn)
+	return b, nil
+}
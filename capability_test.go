@@ -0,0 +1,47 @@
+package bgp
+
+import "testing"
+
+func TestEncodeDecodeCapabilityRoundTrip(t *testing.T) {
+	p, err := EncodeCapability(CapASN4, uint32(4200000000))
+	if err != nil {
+		t.Fatalf("EncodeCapability: %v", err)
+	}
+
+	caps, err := DecodeCapabilities([]Parameter{p})
+	if err != nil {
+		t.Fatalf("DecodeCapabilities: %v", err)
+	}
+	if len(caps) != 1 || caps[0].Code != CapASN4 || caps[0].Value.(uint32) != 4200000000 {
+		t.Fatalf("got %+v, want one CapASN4 capability with value 4200000000", caps)
+	}
+}
+
+func TestDecodeCapabilitiesSkipsUnregisteredCode(t *testing.T) {
+	p := Parameter{Type: paramCapability, Value: []byte{200, 1, 0xff}}
+
+	caps, err := DecodeCapabilities([]Parameter{p})
+	if err != nil {
+		t.Fatalf("DecodeCapabilities: %v", err)
+	}
+	if len(caps) != 0 {
+		t.Fatalf("got %+v, want no decoded capabilities for an unregistered code", caps)
+	}
+}
+
+func TestEncodeCapabilityUnknownCode(t *testing.T) {
+	if _, err := EncodeCapability(250, struct{}{}); err == nil {
+		t.Fatal("expected an error encoding a capability with no registered codec")
+	}
+}
+
+func TestRegisterCapabilityOverridesExisting(t *testing.T) {
+	orig := capabilityRegistry[CapRouteRefresh]
+	defer func() { capabilityRegistry[CapRouteRefresh] = orig }()
+
+	type overrideCodec struct{ routeRefreshCodec }
+	RegisterCapability(CapRouteRefresh, overrideCodec{})
+	if _, ok := capabilityRegistry[CapRouteRefresh].(overrideCodec); !ok {
+		t.Fatal("expected the second registration to replace the Route Refresh codec")
+	}
+}
@@ -0,0 +1,528 @@
+package bgp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// State is a BGP FSM state, as defined in RFC 4271, section 8.
+type State int
+
+const (
+	Idle State = iota
+	Connect
+	Active
+	OpenSent
+	OpenConfirm
+	Established
+)
+
+func (s State) String() string {
+	switch s {
+	case Idle:
+		return "Idle"
+	case Connect:
+		return "Connect"
+	case Active:
+		return "Active"
+	case OpenSent:
+		return "OpenSent"
+	case OpenConfirm:
+		return "OpenConfirm"
+	case Established:
+		return "Established"
+	}
+	return "Unknown"
+}
+
+// Hooks let a caller observe FSM activity without reaching into Session
+// internals. Any field may be left nil.
+type Hooks struct {
+	// OnStateChange is called every time the FSM transitions, including
+	// the initial move out of Idle.
+	OnStateChange func(s *Session, old, new State)
+	// OnNotification is called whenever a NOTIFICATION is sent or
+	// received, just before the session is torn down.
+	OnNotification func(s *Session, n *NOTIFICATION, sent bool)
+}
+
+// Config holds the local parameters used to negotiate a Session.
+type Config struct {
+	MyAS          uint32
+	HoldTime      uint16
+	BGPIdentifier net.IP
+	Parameters    []Parameter
+	Hooks         Hooks
+
+	// GracefulRestart, if non-nil, is advertised in the OPEN via the
+	// Graceful Restart capability (RFC 4724, section 3).
+	GracefulRestart *GracefulRestart
+}
+
+// Session is a single BGP peering session and its finite state machine,
+// as described in RFC 4271, section 8.
+type Session struct {
+	conf Config
+
+	mu    sync.Mutex
+	state State
+	conn  *Conn
+
+	// Updates carries inbound UPDATE messages once the session reaches
+	// Established. It is closed when the session leaves Established.
+	Updates chan *UPDATE
+
+	outbound chan *UPDATE
+	done     chan struct{}
+
+	peerID      net.IP
+	peerAS      uint32
+	holdTime    time.Duration
+	keepaliveIv time.Duration
+
+	bmp BMPSink
+
+	peerGR *GracefulRestart
+	// PreserveForwardingState reports whether the peer's Graceful
+	// Restart capability claims to have preserved forwarding state for
+	// at least one (AFI,SAFI), per RFC 4724 section 3.
+	PreserveForwardingState bool
+}
+
+// PeerGracefulRestart returns the peer's Graceful Restart capability,
+// as received in its OPEN, or nil if it didn't advertise one.
+func (s *Session) PeerGracefulRestart() *GracefulRestart {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peerGR
+}
+
+// BMPSink receives a mirror of session activity for export via BMP
+// (RFC 7854, see the bmp package). Session.AttachBMP lets a caller wire
+// one in without this package depending on any particular BMP client
+// implementation.
+type BMPSink interface {
+	// PeerUp is called once the session reaches Established. sentOpen
+	// and recvOpen are the raw OPEN messages exchanged during the
+	// handshake, as produced by Marshal, per RFC 7854 section 4.10.
+	PeerUp(peerAddress net.IP, peerAS uint32, peerBGPID net.IP, sentOpen, recvOpen []byte)
+	// PeerDown is called when an Established session ends.
+	PeerDown(peerAddress net.IP, peerAS uint32, peerBGPID net.IP, reason uint8)
+	// RouteMonitoring is called for every UPDATE received while
+	// Established. raw is the message as produced by Marshal, so the
+	// sink can forward it without re-encoding.
+	RouteMonitoring(peerAddress net.IP, peerAS uint32, peerBGPID net.IP, raw []byte)
+}
+
+// AttachBMP registers sink to receive BMP mirroring events for the
+// lifetime of the session.
+func (s *Session) AttachBMP(sink BMPSink) {
+	s.mu.Lock()
+	s.bmp = sink
+	s.mu.Unlock()
+}
+
+func (s *Session) remoteAddr() net.IP {
+	if s.conn == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(s.conn.tcp.RemoteAddr().String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// newSession allocates a Session bound to conf, in state Idle.
+func newSession(conf Config) *Session {
+	return &Session{
+		conf:     conf,
+		state:    Idle,
+		Updates:  make(chan *UPDATE, 16),
+		outbound: make(chan *UPDATE, 16),
+		done:     make(chan struct{}),
+	}
+}
+
+// State returns the session's current FSM state.
+func (s *Session) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+func (s *Session) setState(new State) {
+	s.mu.Lock()
+	old := s.state
+	s.state = new
+	s.mu.Unlock()
+	if old != new && s.conf.Hooks.OnStateChange != nil {
+		s.conf.Hooks.OnStateChange(s, old, new)
+	}
+}
+
+// SendUpdate injects an outbound UPDATE. It only succeeds once the
+// session has reached Established.
+func (s *Session) SendUpdate(u *UPDATE) error {
+	if s.State() != Established {
+		return fmt.Errorf("bgp: session not established")
+	}
+	select {
+	case s.outbound <- u:
+		return nil
+	case <-s.done:
+		return fmt.Errorf("bgp: session closed")
+	}
+}
+
+// Close tears the session down, closing the underlying TCP connection.
+func (s *Session) Close() error {
+	select {
+	case <-s.done:
+		return nil
+	default:
+		close(s.done)
+	}
+	s.setState(Idle)
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// Dial actively opens a TCP connection to peer and runs the FSM to
+// Established, per RFC 4271 section 8.2.1 (event 3, TCP connection
+// success moving Connect -> OpenSent).
+func Dial(peer string, conf Config) (*Session, error) {
+	s := newSession(conf)
+	s.setState(Connect)
+
+	tcp, err := net.Dial("tcp", peer)
+	if err != nil {
+		s.setState(Active)
+		return nil, err
+	}
+	s.conn = NewConn(tcp)
+
+	if err := s.openHandshake(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// Listen accepts a single incoming peering session on addr and runs the
+// FSM to Established. Each call handles exactly one connection; callers
+// wanting multiple peers should call Listen in a loop or per-peer
+// goroutine.
+func Listen(addr string, conf Config) (*Session, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+
+	s := newSession(conf)
+	s.setState(Active)
+
+	tcp, err := ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	s.conn = NewConn(tcp)
+
+	if err := s.openHandshake(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// asyncWrite writes m without blocking the caller on the peer reading
+// it, so a simultaneous send/receive (as happens on both sides of the
+// OPEN and KEEPALIVE exchange) can't deadlock on an unbuffered
+// transport such as net.Pipe.
+func (s *Session) asyncWrite(m Message) <-chan error {
+	errc := make(chan error, 1)
+	go func() { errc <- s.conn.WriteMsg(m) }()
+	return errc
+}
+
+// openHandshake drives Connect/Active -> OpenSent -> OpenConfirm ->
+// Established by exchanging OPEN and KEEPALIVE messages.
+func (s *Session) openHandshake() error {
+	params := s.conf.Parameters
+	if s.conf.GracefulRestart != nil {
+		params = append(append([]Parameter{}, params...), NewGracefulRestartParameter(*s.conf.GracefulRestart))
+	}
+	open := NewOPEN(s.conf.MyAS, s.conf.HoldTime, s.conf.BGPIdentifier, params)
+	openWritten := s.asyncWrite(open)
+	s.setState(OpenSent)
+
+	msg, err := s.conn.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if err := <-openWritten; err != nil {
+		return err
+	}
+	peerOpen, ok := msg.(*OPEN)
+	if !ok {
+		s.sendNotification(NotifFSM, 0, nil)
+		return fmt.Errorf("bgp: expected OPEN, got %T", msg)
+	}
+	sentOpen, _ := Marshal(open)
+	recvOpen, _ := Marshal(peerOpen)
+	s.peerID = peerOpen.BGPIdentifier
+	s.peerAS = uint32(peerOpen.MyAS)
+
+	if !s.registerCollision() {
+		return fmt.Errorf("bgp: connection collision with peer %s, closing in favor of the other connection", s.peerID)
+	}
+	defer s.clearCollision()
+
+	if gr, err := DecodeGracefulRestart(peerOpen.Parameters); err == nil && gr != nil {
+		s.peerGR = gr
+		for _, as := range gr.AFISAFIs {
+			if as.Forwarding {
+				s.PreserveForwardingState = true
+				break
+			}
+		}
+	}
+
+	negotiated := s.conf.HoldTime
+	if peerOpen.HoldTime < negotiated {
+		negotiated = peerOpen.HoldTime
+	}
+	s.holdTime = time.Duration(negotiated) * time.Second
+	s.keepaliveIv = s.holdTime / 3
+
+	kaWritten := s.asyncWrite(NewKEEPALIVE())
+	s.setState(OpenConfirm)
+
+	msg, err = s.conn.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if err := <-kaWritten; err != nil {
+		return err
+	}
+	if _, ok := msg.(*KEEPALIVE); !ok {
+		s.sendNotification(NotifFSM, 0, nil)
+		return fmt.Errorf("bgp: expected KEEPALIVE, got %T", msg)
+	}
+
+	s.setState(Established)
+	if s.bmp != nil {
+		s.bmp.PeerUp(s.remoteAddr(), s.peerAS, s.peerID, sentOpen, recvOpen)
+	}
+	return nil
+}
+
+// cmpIP compares two 4-byte BGP Identifiers as unsigned integers.
+func cmpIP(a, b net.IP) int {
+	a, b = a.To4(), b.To4()
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] > b[i] {
+				return 1
+			}
+			return -1
+		}
+	}
+	return 0
+}
+
+// ResolveCollision implements the connection collision detection rule
+// from RFC 4271, section 6.8: when two peers open TCP connections to
+// each other at the same time, the one whose BGP Identifier is
+// numerically lower must close its end of the connection, keeping the
+// one opened by the peer with the higher Identifier. It reports whether
+// the session identified by localID should be kept.
+//
+// openHandshake calls this once both OPENs have been received and tears
+// down the loser with a Cease/Connection Collision Resolution
+// NOTIFICATION; see collisions below.
+func ResolveCollision(localID, remoteID net.IP) bool {
+	return cmpIP(localID, remoteID) > 0
+}
+
+// collisions tracks in-flight (not yet Established) sessions by peer BGP
+// Identifier, so that two simultaneous connection attempts to the same
+// peer can be resolved per RFC 4271 section 6.8. Entries are removed as
+// soon as a session leaves the collision-prone window, either by
+// reaching Established or by losing the race.
+var (
+	collisionsMu sync.Mutex
+	collisions   = map[string]*Session{}
+)
+
+// registerCollision records s as an in-flight connection to its peer and
+// resolves any other in-flight connection already registered for that
+// peer, closing whichever one loses per ResolveCollision. It reports
+// whether s survived.
+func (s *Session) registerCollision() bool {
+	key := s.peerID.String()
+
+	collisionsMu.Lock()
+	other, ok := collisions[key]
+	if !ok {
+		collisions[key] = s
+		collisionsMu.Unlock()
+		return true
+	}
+	collisionsMu.Unlock()
+
+	if !ResolveCollision(s.conf.BGPIdentifier, s.peerID) {
+		s.sendNotification(NotifCease, NotifSubcodeCollisionResolution, nil)
+		return false
+	}
+
+	other.sendNotification(NotifCease, NotifSubcodeCollisionResolution, nil)
+	other.Close()
+
+	collisionsMu.Lock()
+	collisions[key] = s
+	collisionsMu.Unlock()
+	return true
+}
+
+// clearCollision removes s from the in-flight collision registry, if it
+// is still the session registered for its peer.
+func (s *Session) clearCollision() {
+	if s.peerID == nil {
+		return
+	}
+	key := s.peerID.String()
+	collisionsMu.Lock()
+	if collisions[key] == s {
+		delete(collisions, key)
+	}
+	collisionsMu.Unlock()
+}
+
+// NOTIFICATION error codes used internally by the FSM. See RFC 4271,
+// section 4.5, and the IANA BGP error subcode registry.
+const (
+	NotifHoldTimerExpired = 4
+	NotifFSM              = 5
+	NotifCease            = 6
+
+	// NotifSubcodeCollisionResolution is the Cease subcode for a
+	// connection torn down by RFC 4271 section 6.8 collision
+	// resolution.
+	NotifSubcodeCollisionResolution = 7
+)
+
+func (s *Session) sendNotification(code, subcode uint8, data []byte) {
+	n := &NOTIFICATION{Header: newHeader(typeNotification), ErrorCode: code, ErrorSubcode: subcode, Data: data}
+	if s.conn != nil {
+		s.conn.WriteMsg(n)
+	}
+	if s.conf.Hooks.OnNotification != nil {
+		s.conf.Hooks.OnNotification(s, n, true)
+	}
+}
+
+// run drives the Established-state hold timer, keepalive timer and
+// message pump until the session is closed or the peer sends a
+// NOTIFICATION/closes the connection.
+func (s *Session) run() {
+	defer func() {
+		close(s.Updates)
+		if s.bmp != nil {
+			s.bmp.PeerDown(s.remoteAddr(), s.peerAS, s.peerID, 0)
+		}
+		s.Close()
+	}()
+
+	incoming := make(chan Message)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			m, err := s.conn.ReadMsg()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case incoming <- m:
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	// A negotiated HoldTime of 0 disables both the hold timer and the
+	// keepalive timer (RFC 4271, section 4.2). Leave the corresponding
+	// channel nil in that case: a nil channel is never selected, and
+	// time.NewTicker/NewTimer panic on a non-positive interval.
+	var hold *time.Timer
+	var holdC <-chan time.Time
+	if s.holdTime > 0 {
+		hold = time.NewTimer(s.holdTime)
+		defer hold.Stop()
+		holdC = hold.C
+	}
+	var keepaliveC <-chan time.Time
+	if s.keepaliveIv > 0 {
+		keepalive := time.NewTicker(s.keepaliveIv)
+		defer keepalive.Stop()
+		keepaliveC = keepalive.C
+	}
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-holdC:
+			s.sendNotification(NotifHoldTimerExpired, 0, nil)
+			return
+		case <-keepaliveC:
+			if err := s.conn.WriteMsg(NewKEEPALIVE()); err != nil {
+				return
+			}
+		case u := <-s.outbound:
+			if err := s.conn.WriteMsg(u); err != nil {
+				return
+			}
+		case err := <-readErr:
+			_ = err
+			return
+		case m := <-incoming:
+			if s.holdTime > 0 {
+				hold.Reset(s.holdTime)
+			}
+			switch v := m.(type) {
+			case *KEEPALIVE:
+				// resets hold timer above; nothing else to do.
+			case *UPDATE:
+				if s.bmp != nil {
+					if raw, err := Marshal(v); err == nil {
+						s.bmp.RouteMonitoring(s.remoteAddr(), s.peerAS, s.peerID, raw)
+					}
+				}
+				// Drop rather than block if the consumer isn't keeping
+				// up: a full Updates channel must not stall the enclosing
+				// select loop, since that would also stop the hold and
+				// keepalive timers from firing.
+				select {
+				case s.Updates <- v:
+				default:
+				}
+			case *NOTIFICATION:
+				if s.conf.Hooks.OnNotification != nil {
+					s.conf.Hooks.OnNotification(s, v, false)
+				}
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package bgp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func testConfig(id string) Config {
+	return Config{
+		MyAS:          65001,
+		HoldTime:      90,
+		BGPIdentifier: net.ParseIP(id),
+	}
+}
+
+// TestFSMEstablish drives two Sessions through the OPEN/KEEPALIVE
+// handshake over a net.Pipe and checks they both reach Established.
+func TestFSMEstablish(t *testing.T) {
+	a, b := net.Pipe()
+
+	sa := newSession(testConfig("1.1.1.1"))
+	sa.conn = NewConn(a)
+	sb := newSession(testConfig("2.2.2.2"))
+	sb.conn = NewConn(b)
+
+	errc := make(chan error, 2)
+	go func() { errc <- sa.openHandshake() }()
+	go func() { errc <- sb.openHandshake() }()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if err != nil {
+				t.Fatalf("openHandshake: %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for handshake")
+		}
+	}
+
+	if sa.State() != Established {
+		t.Fatalf("side a: got state %v, want Established", sa.State())
+	}
+	if sb.State() != Established {
+		t.Fatalf("side b: got state %v, want Established", sb.State())
+	}
+}
+
+// TestStateString exercises String() for every defined state, including
+// the fallback branch.
+func TestStateString(t *testing.T) {
+	cases := map[State]string{
+		Idle:        "Idle",
+		Connect:     "Connect",
+		Active:      "Active",
+		OpenSent:    "OpenSent",
+		OpenConfirm: "OpenConfirm",
+		Established: "Established",
+		State(99):   "Unknown",
+	}
+	for s, want := range cases {
+		if got := s.String(); got != want {
+			t.Errorf("State(%d).String() = %q, want %q", int(s), got, want)
+		}
+	}
+}
+
+type fakeBMPSink struct {
+	ups   int
+	downs int
+}
+
+func (f *fakeBMPSink) PeerUp(net.IP, uint32, net.IP, []byte, []byte)  { f.ups++ }
+func (f *fakeBMPSink) PeerDown(net.IP, uint32, net.IP, uint8)         { f.downs++ }
+func (f *fakeBMPSink) RouteMonitoring(net.IP, uint32, net.IP, []byte) {}
+
+// TestAttachBMPSeesPeerUp checks that a Session reports PeerUp to an
+// attached BMPSink once the handshake reaches Established.
+func TestAttachBMPSeesPeerUp(t *testing.T) {
+	a, b := net.Pipe()
+
+	sa := newSession(testConfig("1.1.1.1"))
+	sa.conn = NewConn(a)
+	sink := &fakeBMPSink{}
+	sa.AttachBMP(sink)
+
+	sb := newSession(testConfig("2.2.2.2"))
+	sb.conn = NewConn(b)
+
+	errc := make(chan error, 2)
+	go func() { errc <- sa.openHandshake() }()
+	go func() { errc <- sb.openHandshake() }()
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Fatalf("openHandshake: %v", err)
+		}
+	}
+
+	if sink.ups != 1 {
+		t.Fatalf("got %d PeerUp calls, want 1", sink.ups)
+	}
+}
+
+// TestRunZeroHoldTimeDoesNotPanic checks that run() tolerates a
+// negotiated HoldTime of 0 (RFC 4271 section 4.2: disables the hold and
+// keepalive timers) instead of panicking in time.NewTimer/NewTicker.
+func TestRunZeroHoldTimeDoesNotPanic(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	s := newSession(testConfig("1.1.1.1"))
+	s.conn = NewConn(a)
+	s.holdTime = 0
+	s.keepaliveIv = 0
+
+	done := make(chan struct{})
+	go func() {
+		s.run()
+		close(done)
+	}()
+
+	s.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("run() did not return after Close")
+	}
+}
+
+// TestRunDropsUpdatesWhenConsumerSlow checks that a full Updates channel
+// doesn't stall run()'s select loop: the peer must be able to keep
+// sending UPDATEs, and Close must still make run() return promptly,
+// even though nothing is draining s.Updates.
+func TestRunDropsUpdatesWhenConsumerSlow(t *testing.T) {
+	a, b := net.Pipe()
+
+	sa := newSession(testConfig("1.1.1.1"))
+	sa.conn = NewConn(a)
+	sb := newSession(testConfig("2.2.2.2"))
+	sb.conn = NewConn(b)
+
+	errc := make(chan error, 2)
+	go func() { errc <- sa.openHandshake() }()
+	go func() { errc <- sb.openHandshake() }()
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Fatalf("openHandshake: %v", err)
+		}
+	}
+
+	runDone := make(chan struct{})
+	go func() {
+		sa.run()
+		close(runDone)
+	}()
+
+	sendDone := make(chan struct{})
+	go func() {
+		defer close(sendDone)
+		for i := 0; i < cap(sa.Updates)+5; i++ {
+			sb.conn.WriteMsg(NewEndOfRIB())
+		}
+	}()
+
+	select {
+	case <-sendDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("sending UPDATEs blocked: a full Updates channel reached back to the wire")
+	}
+
+	sa.Close()
+	select {
+	case <-runDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("run() did not return after Close with a full Updates channel")
+	}
+}
+
+// TestRunReaderExitsAfterReturnViaNotification checks that the reader
+// goroutine inside run() doesn't leak when run() returns for a reason
+// other than a read error (here, a received NOTIFICATION) while another
+// message is in flight on the wire: the reader must give up delivering
+// it once run() (and its deferred Close) tears the session down.
+func TestRunReaderExitsAfterReturnViaNotification(t *testing.T) {
+	a, b := net.Pipe()
+
+	sa := newSession(testConfig("1.1.1.1"))
+	sa.conn = NewConn(a)
+	sb := newSession(testConfig("2.2.2.2"))
+	sb.conn = NewConn(b)
+
+	errc := make(chan error, 2)
+	go func() { errc <- sa.openHandshake() }()
+	go func() { errc <- sb.openHandshake() }()
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Fatalf("openHandshake: %v", err)
+		}
+	}
+
+	runDone := make(chan struct{})
+	go func() {
+		sa.run()
+		close(runDone)
+	}()
+
+	go func() {
+		sb.conn.WriteMsg(&NOTIFICATION{Header: newHeader(typeNotification), ErrorCode: NotifCease})
+		sb.conn.WriteMsg(NewEndOfRIB())
+	}()
+
+	select {
+	case <-runDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("run() did not return after receiving a NOTIFICATION")
+	}
+}
+
+// TestRegisterCollisionClosesLoser checks that a second in-flight
+// connection to an already-registered peer, whose local BGP Identifier
+// is lower than that peer's, loses the collision per RFC 4271 section
+// 6.8 and leaves the registered connection in place.
+func TestRegisterCollisionClosesLoser(t *testing.T) {
+	winner := newSession(testConfig("9.9.9.9"))
+	winner.peerID = net.ParseIP("5.5.5.5")
+	if !winner.registerCollision() {
+		t.Fatal("first registration should always succeed")
+	}
+	defer winner.clearCollision()
+
+	loser := newSession(testConfig("1.1.1.1"))
+	loser.peerID = net.ParseIP("5.5.5.5")
+	if loser.registerCollision() {
+		t.Fatal("lower BGP Identifier should lose the collision")
+	}
+
+	collisionsMu.Lock()
+	registered := collisions["5.5.5.5"]
+	collisionsMu.Unlock()
+	if registered != winner {
+		t.Fatalf("winner should remain the registered connection for its peer")
+	}
+}
+
+// TestStateChangeHook checks that OnStateChange fires for every
+// transition.
+func TestStateChangeHook(t *testing.T) {
+	var transitions []State
+	conf := testConfig("3.3.3.3")
+	conf.Hooks.OnStateChange = func(s *Session, old, new State) {
+		transitions = append(transitions, new)
+	}
+	s := newSession(conf)
+	s.setState(Connect)
+	s.setState(Established)
+
+	if len(transitions) != 2 || transitions[0] != Connect || transitions[1] != Established {
+		t.Fatalf("unexpected transitions: %v", transitions)
+	}
+}
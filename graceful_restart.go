@@ -0,0 +1,147 @@
+package bgp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Graceful Restart capability code. See RFC 4724, section 3.
+const CapGracefulRestart uint8 = 64
+
+// Graceful Restart flags. See RFC 4724, section 3.
+const (
+	// GRRestartState, set in the capability's Restart Flags, signals
+	// that the sender is restarting and its previous session is
+	// believed to still be active.
+	GRRestartState uint8 = 0x80
+	// GRForwardingPreserved, set per (AFI,SAFI), signals that the
+	// sender has preserved its forwarding state for that address
+	// family across the restart.
+	GRForwardingPreserved uint8 = 0x80
+)
+
+// GRAFISAFI is one (AFI, SAFI, forwarding-state-preserved) entry within
+// a Graceful Restart capability.
+type GRAFISAFI struct {
+	AFI        uint16
+	SAFI       uint8
+	Forwarding bool
+}
+
+// GracefulRestart is the decoded Graceful Restart capability (RFC 4724,
+// section 3).
+type GracefulRestart struct {
+	Restarting  bool
+	RestartTime uint16 // seconds, 12 bits on the wire
+	AFISAFIs    []GRAFISAFI
+}
+
+func (gr GracefulRestart) marshal() []byte {
+	b := make([]byte, 2, 2+3*len(gr.AFISAFIs))
+	flags := uint8(0)
+	if gr.Restarting {
+		flags = GRRestartState
+	}
+	binary.BigEndian.PutUint16(b, uint16(flags)<<8|gr.RestartTime&0x0fff)
+	for _, as := range gr.AFISAFIs {
+		entry := make([]byte, 4)
+		binary.BigEndian.PutUint16(entry[0:2], as.AFI)
+		entry[2] = as.SAFI
+		if as.Forwarding {
+			entry[3] = GRForwardingPreserved
+		}
+		b = append(b, entry...)
+	}
+	return b
+}
+
+func unmarshalGracefulRestart(b []byte) (GracefulRestart, error) {
+	if len(b) < 2 {
+		return GracefulRestart{}, fmt.Errorf("bgp: short Graceful Restart capability")
+	}
+	word := binary.BigEndian.Uint16(b[0:2])
+	gr := GracefulRestart{
+		Restarting:  word&(uint16(GRRestartState)<<8) != 0,
+		RestartTime: word & 0x0fff,
+	}
+	for off := 2; off+4 <= len(b); off += 4 {
+		gr.AFISAFIs = append(gr.AFISAFIs, GRAFISAFI{
+			AFI:        binary.BigEndian.Uint16(b[off : off+2]),
+			SAFI:       b[off+2],
+			Forwarding: b[off+3]&GRForwardingPreserved != 0,
+		})
+	}
+	return gr, nil
+}
+
+type gracefulRestartCodec struct{}
+
+func (gracefulRestartCodec) Decode(value []byte) (interface{}, error) {
+	return unmarshalGracefulRestart(value)
+}
+
+func (gracefulRestartCodec) Encode(v interface{}) ([]byte, error) {
+	gr, ok := v.(GracefulRestart)
+	if !ok {
+		return nil, fmt.Errorf("bgp: Graceful Restart capability needs a GracefulRestart")
+	}
+	return gr.marshal(), nil
+}
+
+// NewGracefulRestartParameter builds an OPEN optional Parameter
+// advertising the Graceful Restart capability.
+func NewGracefulRestartParameter(gr GracefulRestart) Parameter {
+	p, _ := EncodeCapability(CapGracefulRestart, gr)
+	return p
+}
+
+// DecodeGracefulRestart scans params for a Graceful Restart capability.
+func DecodeGracefulRestart(params []Parameter) (*GracefulRestart, error) {
+	caps, err := DecodeCapabilities(params)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range caps {
+		if c.Code == CapGracefulRestart {
+			gr := c.Value.(GracefulRestart)
+			return &gr, nil
+		}
+	}
+	return nil, nil
+}
+
+// NewEndOfRIB builds the IPv4 unicast End-of-RIB marker: an UPDATE with
+// no withdrawn routes, no path attributes, and no NLRI. See RFC 4724,
+// section 2.
+func NewEndOfRIB() *UPDATE { return NewUPDATE(nil, nil, nil) }
+
+// NewEndOfRIBMP builds the End-of-RIB marker for an address family
+// other than IPv4 unicast: an UPDATE whose only path attribute is an
+// MP_UNREACH_NLRI with no withdrawn routes for (afi, safi). See RFC
+// 4724, section 2.
+func NewEndOfRIBMP(afi uint16, safi uint8) *UPDATE {
+	m := &MPUnreachNLRI{AFI: afi, SAFI: safi}
+	return NewUPDATE(nil, []Path{{Flags: FlagOptional, Code: CodeMPUnreachNLRI, Value: m.marshal()}}, nil)
+}
+
+// IsEndOfRIB reports whether u is an End-of-RIB marker, and if so, for
+// which address family.
+func IsEndOfRIB(u *UPDATE) (afi uint16, safi uint8, ok bool) {
+	if len(u.WithdrawnRoutes) != 0 || len(u.ReachabilityInfo) != 0 {
+		return 0, 0, false
+	}
+	switch len(u.Paths) {
+	case 0:
+		return AFIIPv4, SAFIUnicast, true
+	case 1:
+		if u.Paths[0].Code != CodeMPUnreachNLRI {
+			return 0, 0, false
+		}
+		m, err := unmarshalMPUnreachNLRI(u.Paths[0].Value)
+		if err != nil || len(m.Withdrawn) != 0 {
+			return 0, 0, false
+		}
+		return m.AFI, m.SAFI, true
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,62 @@
+package bgp
+
+import "testing"
+
+func TestGracefulRestartParameterRoundTrip(t *testing.T) {
+	gr := GracefulRestart{
+		Restarting:  true,
+		RestartTime: 120,
+		AFISAFIs: []GRAFISAFI{
+			{AFI: AFIIPv4, SAFI: SAFIUnicast, Forwarding: true},
+			{AFI: AFIIPv6, SAFI: SAFIUnicast, Forwarding: false},
+		},
+	}
+	p := NewGracefulRestartParameter(gr)
+
+	got, err := DecodeGracefulRestart([]Parameter{p})
+	if err != nil {
+		t.Fatalf("DecodeGracefulRestart: %v", err)
+	}
+	if got == nil {
+		t.Fatal("expected a decoded Graceful Restart capability")
+	}
+	if !got.Restarting || got.RestartTime != 120 || len(got.AFISAFIs) != 2 {
+		t.Fatalf("got %+v, want %+v", got, gr)
+	}
+	if !got.AFISAFIs[0].Forwarding || got.AFISAFIs[1].Forwarding {
+		t.Fatalf("got AFISAFIs %+v, want matching forwarding flags", got.AFISAFIs)
+	}
+}
+
+func TestDecodeGracefulRestartAbsent(t *testing.T) {
+	got, err := DecodeGracefulRestart(nil)
+	if err != nil {
+		t.Fatalf("DecodeGracefulRestart: %v", err)
+	}
+	if got != nil {
+		t.Fatalf("got %+v, want nil", got)
+	}
+}
+
+func TestEndOfRIBIPv4(t *testing.T) {
+	u := NewEndOfRIB()
+	afi, safi, ok := IsEndOfRIB(u)
+	if !ok || afi != AFIIPv4 || safi != SAFIUnicast {
+		t.Fatalf("got (%d, %d, %v), want (%d, %d, true)", afi, safi, ok, AFIIPv4, SAFIUnicast)
+	}
+}
+
+func TestEndOfRIBMP(t *testing.T) {
+	u := NewEndOfRIBMP(AFIIPv6, SAFIUnicast)
+	afi, safi, ok := IsEndOfRIB(u)
+	if !ok || afi != AFIIPv6 || safi != SAFIUnicast {
+		t.Fatalf("got (%d, %d, %v), want (%d, %d, true)", afi, safi, ok, AFIIPv6, SAFIUnicast)
+	}
+}
+
+func TestIsEndOfRIBFalseForRealUpdate(t *testing.T) {
+	u := NewUPDATE(nil, []Path{{Code: Origin, Value: []byte{0}}}, nil)
+	if _, _, ok := IsEndOfRIB(u); ok {
+		t.Fatal("expected a real UPDATE to not be an End-of-RIB marker")
+	}
+}
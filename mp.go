@@ -0,0 +1,239 @@
+package bgp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// Address Family Identifiers, from the IANA "Address Family Numbers"
+// registry, as referenced by RFC 4760.
+const (
+	AFIIPv4  uint16 = 1
+	AFIIPv6  uint16 = 2
+	AFIL2VPN uint16 = 25
+)
+
+// Subsequent Address Family Identifiers.
+const (
+	SAFIUnicast uint8 = 1
+	SAFIMPLSVPN uint8 = 128 // VPNv4/VPNv6, RFC 4364.
+	SAFIVPLS    uint8 = 65  // L2VPN, RFC 4761.
+)
+
+// MPReachNLRI is the MP_REACH_NLRI path attribute (RFC 4760, section 3).
+// It carries reachable routes for address families other than plain
+// IPv4 unicast.
+type MPReachNLRI struct {
+	AFI     uint16
+	SAFI    uint8
+	NextHop net.IP
+	NLRI    []Prefix
+}
+
+// MPUnreachNLRI is the MP_UNREACH_NLRI path attribute (RFC 4760,
+// section 4). It carries withdrawn routes for address families other
+// than plain IPv4 unicast.
+type MPUnreachNLRI struct {
+	AFI       uint16
+	SAFI      uint8
+	Withdrawn []Prefix
+}
+
+// marshal encodes the attribute value as it appears in a Path's Value
+// field.
+func (m *MPReachNLRI) marshal() []byte {
+	nhLen := len(m.NextHop)
+	b := make([]byte, 0, 5+nhLen+1)
+	b = append(b, 0, 0) // AFI, filled below
+	binary.BigEndian.PutUint16(b[0:2], m.AFI)
+	b = append(b, m.SAFI)
+	b = append(b, uint8(nhLen))
+	b = append(b, m.NextHop...)
+	b = append(b, 0) // Reserved (SNPA count, always zero: we don't support SNPA)
+	for _, p := range m.NLRI {
+		bits, _ := p.Mask.Size()
+		nbytes := (bits + 7) / 8
+		b = append(b, uint8(bits))
+		b = append(b, p.IP[:nbytes]...)
+	}
+	return b
+}
+
+func unmarshalMPReachNLRI(b []byte) (*MPReachNLRI, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("bgp: short MP_REACH_NLRI")
+	}
+	m := &MPReachNLRI{
+		AFI:  binary.BigEndian.Uint16(b[0:2]),
+		SAFI: b[2],
+	}
+	nhLen := int(b[3])
+	off := 4
+	if off+nhLen > len(b) {
+		return nil, fmt.Errorf("bgp: truncated MP_REACH_NLRI next hop")
+	}
+	m.NextHop = append(net.IP(nil), b[off:off+nhLen]...)
+	off += nhLen
+
+	off++ // skip reserved SNPA count octet
+
+	for off < len(b) {
+		bits := int(b[off])
+		nbytes := (bits + 7) / 8
+		off++
+		if off+nbytes > len(b) {
+			return nil, fmt.Errorf("bgp: truncated MP_REACH_NLRI prefix")
+		}
+		ip := make(net.IP, nbytes)
+		copy(ip, b[off:off+nbytes])
+		off += nbytes
+		m.NLRI = append(m.NLRI, Prefix{IP: ip, Mask: mpMask(m.AFI, bits), AFI: m.AFI, SAFI: m.SAFI})
+	}
+	return m, nil
+}
+
+func (m *MPUnreachNLRI) marshal() []byte {
+	b := make([]byte, 3)
+	binary.BigEndian.PutUint16(b[0:2], m.AFI)
+	b[2] = m.SAFI
+	for _, p := range m.Withdrawn {
+		bits, _ := p.Mask.Size()
+		nbytes := (bits + 7) / 8
+		b = append(b, uint8(bits))
+		b = append(b, p.IP[:nbytes]...)
+	}
+	return b
+}
+
+func unmarshalMPUnreachNLRI(b []byte) (*MPUnreachNLRI, error) {
+	if len(b) < 3 {
+		return nil, fmt.Errorf("bgp: short MP_UNREACH_NLRI")
+	}
+	m := &MPUnreachNLRI{
+		AFI:  binary.BigEndian.Uint16(b[0:2]),
+		SAFI: b[2],
+	}
+	off := 3
+	for off < len(b) {
+		bits := int(b[off])
+		nbytes := (bits + 7) / 8
+		off++
+		if off+nbytes > len(b) {
+			return nil, fmt.Errorf("bgp: truncated MP_UNREACH_NLRI prefix")
+		}
+		ip := make(net.IP, nbytes)
+		copy(ip, b[off:off+nbytes])
+		off += nbytes
+		m.Withdrawn = append(m.Withdrawn, Prefix{IP: ip, Mask: mpMask(m.AFI, bits), AFI: m.AFI, SAFI: m.SAFI})
+	}
+	return m, nil
+}
+
+// mpMask builds the net.IPMask for a prefix of the given bit length
+// within afi, defaulting to a 32-bit address family for anything that
+// isn't IPv6.
+func mpMask(afi uint16, bits int) net.IPMask {
+	if afi == AFIIPv6 {
+		return net.CIDRMask(bits, 128)
+	}
+	return net.CIDRMask(bits, 32)
+}
+
+// NewMPReachIPv6 builds a Path carrying an MP_REACH_NLRI attribute
+// advertising the given IPv6 prefixes reachable via nextHop.
+func NewMPReachIPv6(nextHop net.IP, nlri []Prefix) Path {
+	m := &MPReachNLRI{AFI: AFIIPv6, SAFI: SAFIUnicast, NextHop: nextHop, NLRI: nlri}
+	return Path{Flags: FlagOptional, Code: CodeMPReachNLRI, Value: m.marshal()}
+}
+
+// NewMPUnreachIPv6 builds a Path carrying an MP_UNREACH_NLRI attribute
+// withdrawing the given IPv6 prefixes.
+func NewMPUnreachIPv6(withdrawn []Prefix) Path {
+	m := &MPUnreachNLRI{AFI: AFIIPv6, SAFI: SAFIUnicast, Withdrawn: withdrawn}
+	return Path{Flags: FlagOptional, Code: CodeMPUnreachNLRI, Value: m.marshal()}
+}
+
+// DecodeMPReachNLRI extracts the MP_REACH_NLRI attribute from a Path,
+// returning an error if p is not of that type.
+func DecodeMPReachNLRI(p Path) (*MPReachNLRI, error) {
+	if p.Code != CodeMPReachNLRI {
+		return nil, fmt.Errorf("bgp: path code %d is not MP_REACH_NLRI", p.Code)
+	}
+	return unmarshalMPReachNLRI(p.Value)
+}
+
+// DecodeMPUnreachNLRI extracts the MP_UNREACH_NLRI attribute from a
+// Path, returning an error if p is not of that type.
+func DecodeMPUnreachNLRI(p Path) (*MPUnreachNLRI, error) {
+	if p.Code != CodeMPUnreachNLRI {
+		return nil, fmt.Errorf("bgp: path code %d is not MP_UNREACH_NLRI", p.Code)
+	}
+	return unmarshalMPUnreachNLRI(p.Value)
+}
+
+// Optional Parameter type for the Capabilities parameter. See RFC 5492.
+const paramCapability uint8 = 2
+
+// Capability codes. See the IANA "Capability Codes" registry.
+const (
+	CapMultiprotocol uint8 = 1
+)
+
+// AFISAFI is an (AFI, SAFI) tuple, as advertised by the Multiprotocol
+// Extensions capability (RFC 4760, section 8).
+type AFISAFI struct {
+	AFI  uint16
+	SAFI uint8
+}
+
+// NewMultiprotocolParameter builds an OPEN optional Parameter
+// advertising the Multiprotocol Extensions capability (code 1) for
+// every (AFI, SAFI) pair in pairs, per RFC 4760 section 8 / RFC 5492.
+func NewMultiprotocolParameter(pairs ...AFISAFI) Parameter {
+	var value []byte
+	for _, p := range pairs {
+		tlv := make([]byte, 4)
+		binary.BigEndian.PutUint16(tlv[0:2], p.AFI)
+		tlv[2] = 0 // Reserved.
+		tlv[3] = p.SAFI
+		value = append(value, CapMultiprotocol, uint8(len(tlv)))
+		value = append(value, tlv...)
+	}
+	return Parameter{Type: paramCapability, Value: value}
+}
+
+// MultiprotocolAFISAFIs scans an OPEN's Parameters for Multiprotocol
+// Extensions capabilities and returns every (AFI, SAFI) pair
+// advertised.
+func MultiprotocolAFISAFIs(params []Parameter) ([]AFISAFI, error) {
+	var out []AFISAFI
+	for _, param := range params {
+		if param.Type != paramCapability {
+			continue
+		}
+		b := param.Value
+		for off := 0; off < len(b); {
+			if off+2 > len(b) {
+				return nil, fmt.Errorf("bgp: truncated capability")
+			}
+			code := b[off]
+			l := int(b[off+1])
+			off += 2
+			if off+l > len(b) {
+				return nil, fmt.Errorf("bgp: truncated capability value")
+			}
+			if code == CapMultiprotocol {
+				if l != 4 {
+					return nil, fmt.Errorf("bgp: malformed multiprotocol capability")
+				}
+				out = append(out, AFISAFI{
+					AFI:  binary.BigEndian.Uint16(b[off : off+2]),
+					SAFI: b[off+3],
+				})
+			}
+			off += l
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,60 @@
+package bgp
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestMPReachNLRIRoundTrip(t *testing.T) {
+	nlri := []Prefix{
+		{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128)},
+		{IP: net.ParseIP("2001:db8:1::"), Mask: net.CIDRMask(48, 128)},
+	}
+	p := NewMPReachIPv6(net.ParseIP("2001:db8::1"), nlri)
+
+	got, err := DecodeMPReachNLRI(p)
+	if err != nil {
+		t.Fatalf("DecodeMPReachNLRI: %v", err)
+	}
+	if got.AFI != AFIIPv6 || got.SAFI != SAFIUnicast {
+		t.Fatalf("got AFI/SAFI %d/%d, want %d/%d", got.AFI, got.SAFI, AFIIPv6, SAFIUnicast)
+	}
+	if len(got.NLRI) != 2 {
+		t.Fatalf("got %d NLRI entries, want 2", len(got.NLRI))
+	}
+	if bits, _ := got.NLRI[1].Mask.Size(); bits != 48 {
+		t.Errorf("second NLRI mask = %d bits, want 48", bits)
+	}
+}
+
+func TestMPUnreachNLRIRoundTrip(t *testing.T) {
+	withdrawn := []Prefix{
+		{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128)},
+	}
+	p := NewMPUnreachIPv6(withdrawn)
+
+	got, err := DecodeMPUnreachNLRI(p)
+	if err != nil {
+		t.Fatalf("DecodeMPUnreachNLRI: %v", err)
+	}
+	if got.AFI != AFIIPv6 || len(got.Withdrawn) != 1 {
+		t.Fatalf("unexpected decode result: %+v", got)
+	}
+}
+
+func TestMultiprotocolParameterRoundTrip(t *testing.T) {
+	want := []AFISAFI{
+		{AFI: AFIIPv4, SAFI: SAFIMPLSVPN},
+		{AFI: AFIIPv6, SAFI: SAFIUnicast},
+	}
+	param := NewMultiprotocolParameter(want...)
+
+	got, err := MultiprotocolAFISAFIs([]Parameter{param})
+	if err != nil {
+		t.Fatalf("MultiprotocolAFISAFIs: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
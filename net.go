@@ -0,0 +1,405 @@
+package bgp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// marker is the 16-octet BGP marker. We don't implement the (deprecated)
+// authentication scheme from RFC 1771, so it is always all ones, per
+// RFC 4271, section 4.1.
+var marker = [16]byte{
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+}
+
+// Conn wraps a net.TCPConn and frames BGP messages using the 16-byte
+// marker plus the fixed Header described in RFC 4271, section 4.1. It is
+// defined in terms of the net.Conn interface so tests can drive the FSM
+// over a net.Pipe instead of a real TCP socket.
+type Conn struct {
+	tcp net.Conn
+}
+
+// NewConn wraps an already-established connection (typically a
+// *net.TCPConn) for BGP framing.
+func NewConn(tcp net.Conn) *Conn { return &Conn{tcp: tcp} }
+
+// Close closes the underlying connection.
+func (c *Conn) Close() error { return c.tcp.Close() }
+
+// ReadMsg reads a single framed BGP message off the wire.
+func (c *Conn) ReadMsg() (Message, error) {
+	var hdr [headerLen]byte
+	if _, err := readFull(c.tcp, hdr[:]); err != nil {
+		return nil, err
+	}
+	for _, b := range hdr[:16] {
+		if b != 0xff {
+			return nil, fmt.Errorf("bgp: bad marker in header")
+		}
+	}
+	length := binary.BigEndian.Uint16(hdr[16:18])
+	typ := hdr[18]
+	if int(length) < headerLen || int(length) > MaxSize {
+		return nil, fmt.Errorf("bgp: invalid message length %d", length)
+	}
+
+	body := make([]byte, int(length)-headerLen)
+	if _, err := readFull(c.tcp, body); err != nil {
+		return nil, err
+	}
+
+	m, err := newMessage(typ)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.unpack(body); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Marshal encodes m exactly as it would be put on the wire: the 16-byte
+// marker, the Header, and the packed message body. Callers that need
+// the raw bytes of a message without opening a Conn (for example to
+// mirror them to a BMP collector) can use this directly.
+func Marshal(m Message) ([]byte, error) {
+	buf := make([]byte, m.Len())
+	copy(buf[0:16], marker[:])
+	binary.BigEndian.PutUint16(buf[16:18], uint16(m.Len()))
+	buf[18] = typeOf(m)
+	if _, err := m.pack(buf[headerLen:]); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// WriteMsg marshals m and writes it to the connection as a single framed
+// BGP message.
+func (c *Conn) WriteMsg(m Message) error {
+	buf, err := Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = c.tcp.Write(buf)
+	return err
+}
+
+func readFull(c net.Conn, b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		k, err := c.Read(b[n:])
+		n += k
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func typeOf(m Message) uint8 {
+	switch m.(type) {
+	case *OPEN:
+		return typeOpen
+	case *UPDATE:
+		return typeUpdate
+	case *NOTIFICATION:
+		return typeNotification
+	case *KEEPALIVE:
+		return typeKeepalive
+	}
+	return 0
+}
+
+func newMessage(typ uint8) (Message, error) {
+	switch typ {
+	case typeOpen:
+		return &OPEN{Header: &Header{}}, nil
+	case typeUpdate:
+		return &UPDATE{Header: &Header{}}, nil
+	case typeNotification:
+		return &NOTIFICATION{Header: &Header{}}, nil
+	case typeKeepalive:
+		return &KEEPALIVE{Header: &Header{}}, nil
+	}
+	return nil, fmt.Errorf("bgp: unknown message type %d", typ)
+}
+
+// pack/unpack implementations. These operate on the message body only;
+// the marker and Header are handled by Conn.
+
+func (m *OPEN) pack(b []byte) (int, error) {
+	if len(b) < 10 {
+		return 0, fmt.Errorf("bgp: short buffer for OPEN")
+	}
+	b[0] = m.Version
+	binary.BigEndian.PutUint16(b[1:3], twoOctetAS(m.MyAS))
+	binary.BigEndian.PutUint16(b[3:5], m.HoldTime)
+	copy(b[5:9], m.BGPIdentifier.To4())
+
+	off := 10
+	paramLen := 0
+	for _, p := range m.Parameters {
+		n, err := p.pack(b[off:])
+		if err != nil {
+			return 0, err
+		}
+		off += n
+		paramLen += n
+	}
+	b[9] = uint8(paramLen)
+	return off, nil
+}
+
+func (m *OPEN) unpack(b []byte) (int, error) {
+	if len(b) < 10 {
+		return 0, fmt.Errorf("bgp: short OPEN")
+	}
+	m.Version = b[0]
+	m.MyAS = uint32(binary.BigEndian.Uint16(b[1:3]))
+	m.HoldTime = binary.BigEndian.Uint16(b[3:5])
+	m.BGPIdentifier = net.IP(append([]byte(nil), b[5:9]...))
+
+	optLen := int(b[9])
+	off := 10
+	end := off + optLen
+	if end > len(b) {
+		return 0, fmt.Errorf("bgp: truncated OPEN parameters")
+	}
+	m.Parameters = nil
+	for off < end {
+		p := Parameter{}
+		n, err := p.unpack(b[off:end])
+		if err != nil {
+			return 0, err
+		}
+		m.Parameters = append(m.Parameters, p)
+		off += n
+	}
+
+	if asn, ok := ASNCapability(m.Parameters); ok {
+		m.MyAS = asn
+	}
+	return off, nil
+}
+
+// twoOctetAS returns as truncated to fit the legacy 2-octet OPEN field,
+// substituting AS_TRANS (23456) when it doesn't fit. See RFC 6793,
+// section 4.1.
+func twoOctetAS(as uint32) uint16 {
+	if as > 0xffff {
+		return ASTrans
+	}
+	return uint16(as)
+}
+
+func (p *Parameter) pack(b []byte) (int, error) {
+	if len(b) < 2+len(p.Value) {
+		return 0, fmt.Errorf("bgp: short buffer for Parameter")
+	}
+	b[0] = p.Type
+	b[1] = uint8(len(p.Value))
+	copy(b[2:], p.Value)
+	return p.len(), nil
+}
+
+func (p *Parameter) unpack(b []byte) (int, error) {
+	if len(b) < 2 {
+		return 0, fmt.Errorf("bgp: short Parameter")
+	}
+	p.Type = b[0]
+	l := int(b[1])
+	if len(b) < 2+l {
+		return 0, fmt.Errorf("bgp: truncated Parameter")
+	}
+	p.Value = append([]byte(nil), b[2:2+l]...)
+	return 2 + l, nil
+}
+
+func (m *UPDATE) pack(b []byte) (int, error) {
+	off := 2
+	wLen := 0
+	for _, p := range m.WithdrawnRoutes {
+		n, err := p.pack(b[off:])
+		if err != nil {
+			return 0, err
+		}
+		off += n
+		wLen += n
+	}
+	binary.BigEndian.PutUint16(b[0:2], uint16(wLen))
+
+	aOff := off
+	off += 2
+	aLen := 0
+	for _, p := range m.Paths {
+		n, err := p.pack(b[off:])
+		if err != nil {
+			return 0, err
+		}
+		off += n
+		aLen += n
+	}
+	binary.BigEndian.PutUint16(b[aOff:aOff+2], uint16(aLen))
+
+	for _, p := range m.ReachabilityInfo {
+		n, err := p.pack(b[off:])
+		if err != nil {
+			return 0, err
+		}
+		off += n
+	}
+	return off, nil
+}
+
+func (m *UPDATE) unpack(b []byte) (int, error) {
+	if len(b) < 2 {
+		return 0, fmt.Errorf("bgp: short UPDATE")
+	}
+	wLen := int(binary.BigEndian.Uint16(b[0:2]))
+	off := 2
+	if off+wLen > len(b) {
+		return 0, fmt.Errorf("bgp: truncated withdrawn routes")
+	}
+	m.WithdrawnRoutes = nil
+	end := off + wLen
+	for off < end {
+		p := Prefix{}
+		n, err := p.unpack(b[off:end])
+		if err != nil {
+			return 0, err
+		}
+		m.WithdrawnRoutes = append(m.WithdrawnRoutes, p)
+		off += n
+	}
+
+	if off+2 > len(b) {
+		return 0, fmt.Errorf("bgp: short UPDATE path attributes")
+	}
+	aLen := int(binary.BigEndian.Uint16(b[off : off+2]))
+	off += 2
+	if off+aLen > len(b) {
+		return 0, fmt.Errorf("bgp: truncated path attributes")
+	}
+	m.Paths = nil
+	end = off + aLen
+	for off < end {
+		p := Path{}
+		n, err := p.unpack(b[off:end])
+		if err != nil {
+			return 0, err
+		}
+		m.Paths = append(m.Paths, p)
+		off += n
+	}
+
+	m.ReachabilityInfo = nil
+	for off < len(b) {
+		p := Prefix{}
+		n, err := p.unpack(b[off:])
+		if err != nil {
+			return 0, err
+		}
+		m.ReachabilityInfo = append(m.ReachabilityInfo, p)
+		off += n
+	}
+	return off, nil
+}
+
+func (p *Path) pack(b []byte) (int, error) {
+	b[0] = p.Flags
+	b[1] = p.Code
+	var off int
+	if p.Flags&FlagLength == FlagLength {
+		binary.BigEndian.PutUint16(b[2:4], uint16(len(p.Value)))
+		off = 4
+	} else {
+		b[2] = uint8(len(p.Value))
+		off = 3
+	}
+	copy(b[off:], p.Value)
+	return off + len(p.Value), nil
+}
+
+func (p *Path) unpack(b []byte) (int, error) {
+	if len(b) < 2 {
+		return 0, fmt.Errorf("bgp: short Path attribute")
+	}
+	p.Flags = b[0]
+	p.Code = b[1]
+	off := 2
+	var l int
+	if p.Flags&FlagLength == FlagLength {
+		if len(b) < 4 {
+			return 0, fmt.Errorf("bgp: short extended-length Path attribute")
+		}
+		l = int(binary.BigEndian.Uint16(b[2:4]))
+		off = 4
+	} else {
+		l = int(b[2])
+		off = 3
+	}
+	if off+l > len(b) {
+		return 0, fmt.Errorf("bgp: truncated Path attribute")
+	}
+	p.Value = append([]byte(nil), b[off:off+l]...)
+	return off + l, nil
+}
+
+func (p *Prefix) pack(b []byte) (int, error) {
+	bits, _ := p.Mask.Size()
+	nbytes := (bits + 7) / 8
+	if len(b) < 1+nbytes {
+		return 0, fmt.Errorf("bgp: short buffer for Prefix")
+	}
+	b[0] = uint8(bits)
+	copy(b[1:1+nbytes], p.IP)
+	return 1 + nbytes, nil
+}
+
+func (p *Prefix) unpack(b []byte) (int, error) {
+	if len(b) < 1 {
+		return 0, fmt.Errorf("bgp: short Prefix")
+	}
+	bits := int(b[0])
+	nbytes := (bits + 7) / 8
+	if len(b) < 1+nbytes {
+		return 0, fmt.Errorf("bgp: truncated Prefix")
+	}
+	ip := make(net.IP, 4)
+	copy(ip, b[1:1+nbytes])
+	p.IP = ip
+	p.Mask = net.CIDRMask(bits, 32)
+	// The plain WithdrawnRoutes/ReachabilityInfo fields only ever carry
+	// IPv4 unicast NLRI (RFC 4271); set AFI/SAFI explicitly so a decoded
+	// Prefix compares and hashes the same as one built by hand or by an
+	// MP_REACH_NLRI/MP_UNREACH_NLRI constructor (see rib.prefixKey).
+	p.AFI = AFIIPv4
+	p.SAFI = SAFIUnicast
+	return 1 + nbytes, nil
+}
+
+func (m *KEEPALIVE) pack(b []byte) (int, error)   { return 0, nil }
+func (m *KEEPALIVE) unpack(b []byte) (int, error) { return 0, nil }
+
+func (m *NOTIFICATION) pack(b []byte) (int, error) {
+	if len(b) < 2+len(m.Data) {
+		return 0, fmt.Errorf("bgp: short buffer for NOTIFICATION")
+	}
+	b[0] = m.ErrorCode
+	b[1] = m.ErrorSubcode
+	copy(b[2:], m.Data)
+	return 2 + len(m.Data), nil
+}
+
+func (m *NOTIFICATION) unpack(b []byte) (int, error) {
+	if len(b) < 2 {
+		return 0, fmt.Errorf("bgp: short NOTIFICATION")
+	}
+	m.ErrorCode = b[0]
+	m.ErrorSubcode = b[1]
+	m.Data = append([]byte(nil), b[2:]...)
+	return len(b), nil
+}
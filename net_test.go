@@ -0,0 +1,43 @@
+package bgp
+
+import (
+	"net"
+	"testing"
+)
+
+// TestUpdateReachabilityInfoGetsIPv4UnicastAFISAFI checks that a Prefix
+// decoded from the classic (non-MP) ReachabilityInfo/WithdrawnRoutes
+// fields comes back tagged AFI/SAFI (1, 1), matching a Prefix built by
+// hand for IPv4 unicast, so the two key the same rib.RIB entry.
+func TestUpdateReachabilityInfoGetsIPv4UnicastAFISAFI(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	sent := NewUPDATE(nil, nil, []Prefix{
+		{IP: net.ParseIP("10.0.0.0").To4(), Mask: net.CIDRMask(24, 32)},
+	})
+
+	errc := make(chan error, 1)
+	go func() { errc <- NewConn(a).WriteMsg(sent) }()
+
+	got, err := NewConn(b).ReadMsg()
+	if err != nil {
+		t.Fatalf("ReadMsg: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("WriteMsg: %v", err)
+	}
+
+	u, ok := got.(*UPDATE)
+	if !ok {
+		t.Fatalf("got %T, want *UPDATE", got)
+	}
+	if len(u.ReachabilityInfo) != 1 {
+		t.Fatalf("got %d ReachabilityInfo entries, want 1", len(u.ReachabilityInfo))
+	}
+	p := u.ReachabilityInfo[0]
+	if p.AFI != AFIIPv4 || p.SAFI != SAFIUnicast {
+		t.Fatalf("got AFI/SAFI %d/%d, want %d/%d", p.AFI, p.SAFI, AFIIPv4, SAFIUnicast)
+	}
+}
@@ -0,0 +1,90 @@
+package rib
+
+import "time"
+
+// MarkPeerStale flags every route in peer's Adj-RIB-In as stale, without
+// removing it from Loc-RIB. A stale route stays eligible for best-path
+// selection, so traffic keeps flowing over it, until ScheduleSweep's
+// timer fires or the peer re-advertises it (which clears Stale; see
+// set). Call this when a peer's TCP session is lost but it has
+// negotiated Graceful Restart (RFC 4724, section 4).
+func (r *RIB) MarkPeerStale(peer string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, peers := range r.adjIn {
+		if route, ok := peers[peer]; ok {
+			route.Stale = true
+		}
+	}
+}
+
+// SweepStale removes every still-stale route from peer's Adj-RIB-In and
+// re-runs best-path selection for the prefixes it held. Call this once
+// peer's restart timer expires without the session re-establishing.
+func (r *RIB) SweepStale(peer string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweep(peer, nil)
+}
+
+// SweepStaleAFISAFI is like SweepStale, but only sweeps routes for the
+// given address family. Use this to honor a peer's per-(AFI,SAFI)
+// Graceful Restart state when its stale timers for different address
+// families expire independently (RFC 4724, section 4.2).
+func (r *RIB) SweepStaleAFISAFI(peer string, afi uint16, safi uint8) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweep(peer, &afiSafi{afi, safi})
+}
+
+type afiSafi struct {
+	afi  uint16
+	safi uint8
+}
+
+// sweep removes peer's stale routes matching filter (or every address
+// family, if filter is nil). Callers must hold r.mu.
+func (r *RIB) sweep(peer string, filter *afiSafi) {
+	for key, peers := range r.adjIn {
+		route, ok := peers[peer]
+		if !ok || !route.Stale {
+			continue
+		}
+		if filter != nil && (route.Prefix.AFI != filter.afi || route.Prefix.SAFI != filter.safi) {
+			continue
+		}
+		delete(peers, peer)
+		if len(peers) == 0 {
+			delete(r.adjIn, key)
+		}
+		r.selectBest(route.Prefix, key)
+	}
+}
+
+// SweepStaleOnEoR is like SweepStaleAFISAFI, but is meant to be called
+// as soon as peer's End-of-RIB marker for (afi, safi) arrives (see
+// bgp.IsEndOfRIB), rather than waiting for its restart timer. RFC 4724,
+// section 4.1, has the receiving speaker sweep any routes peer didn't
+// refresh before its own End-of-RIB, instead of leaving them stale for
+// the full restart interval. The caller should still Stop any timer it
+// scheduled with ScheduleSweep/ScheduleSweepAFISAFI for this peer, since
+// this call makes it redundant.
+func (r *RIB) SweepStaleOnEoR(peer string, afi uint16, safi uint8) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweep(peer, &afiSafi{afi, safi})
+}
+
+// ScheduleSweep arranges for SweepStale(peer) to run after d, the
+// negotiated Graceful Restart time for peer. The caller should Stop the
+// returned timer if the peer re-establishes and refreshes its routes
+// before the timer fires.
+func (r *RIB) ScheduleSweep(peer string, d time.Duration) *time.Timer {
+	return time.AfterFunc(d, func() { r.SweepStale(peer) })
+}
+
+// ScheduleSweepAFISAFI is like ScheduleSweep, but sweeps only the given
+// address family when d elapses.
+func (r *RIB) ScheduleSweepAFISAFI(peer string, afi uint16, safi uint8, d time.Duration) *time.Timer {
+	return time.AfterFunc(d, func() { r.SweepStaleAFISAFI(peer, afi, safi) })
+}
@@ -0,0 +1,100 @@
+package rib
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jrossi/bgp"
+)
+
+func TestMarkPeerStaleKeepsRouteSelectable(t *testing.T) {
+	r := New(nil)
+	p := prefix("10.1.0.0", 24)
+	r.Update("peerA", update([]bgp.Prefix{p}, nil), true, net.ParseIP("1.1.1.1"), false)
+
+	r.MarkPeerStale("peerA")
+
+	best := r.Best(p)
+	if best == nil || !best.Stale {
+		t.Fatalf("got %+v, want a stale but still-selected route", best)
+	}
+}
+
+func TestSweepStaleRemovesOnlyStaleRoutes(t *testing.T) {
+	r := New(nil)
+	fresh := prefix("10.2.0.0", 24)
+	stale := prefix("10.2.1.0", 24)
+	r.Update("peerA", update([]bgp.Prefix{fresh, stale}, nil), true, net.ParseIP("1.1.1.1"), false)
+
+	r.MarkPeerStale("peerA")
+	r.Update("peerA", update([]bgp.Prefix{fresh}, nil), true, net.ParseIP("1.1.1.1"), false)
+	r.SweepStale("peerA")
+
+	if r.Best(fresh) == nil {
+		t.Fatal("expected the re-advertised route to survive the sweep")
+	}
+	if r.Best(stale) != nil {
+		t.Fatal("expected the unrefreshed stale route to be swept")
+	}
+}
+
+func TestSweepStaleAFISAFIOnlyAffectsMatchingFamily(t *testing.T) {
+	r := New(nil)
+	v4 := prefix("10.3.0.0", 24)
+	v6 := bgp.Prefix{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128), AFI: bgp.AFIIPv6, SAFI: bgp.SAFIUnicast}
+	r.Update("peerA", update([]bgp.Prefix{v4}, nil), true, net.ParseIP("1.1.1.1"), false)
+	r.Update("peerA", update([]bgp.Prefix{v6}, nil), true, net.ParseIP("1.1.1.1"), false)
+
+	r.MarkPeerStale("peerA")
+	r.SweepStaleAFISAFI("peerA", bgp.AFIIPv6, bgp.SAFIUnicast)
+
+	if r.Best(v6) != nil {
+		t.Fatal("expected the IPv6 stale route to be swept")
+	}
+	if r.Best(v4) == nil {
+		t.Fatal("expected the IPv4 route to be unaffected by an IPv6-only sweep")
+	}
+}
+
+func TestSweepStaleOnEoRSweepsImmediately(t *testing.T) {
+	r := New(nil)
+	fresh := prefix("10.5.0.0", 24)
+	stale := prefix("10.5.1.0", 24)
+	r.Update("peerA", update([]bgp.Prefix{fresh, stale}, nil), true, net.ParseIP("1.1.1.1"), false)
+
+	r.MarkPeerStale("peerA")
+	r.Update("peerA", update([]bgp.Prefix{fresh}, nil), true, net.ParseIP("1.1.1.1"), false)
+
+	// Simulate peerA's End-of-RIB for this family arriving before any
+	// restart timer would fire.
+	r.SweepStaleOnEoR("peerA", bgp.AFIIPv4, bgp.SAFIUnicast)
+
+	if r.Best(fresh) == nil {
+		t.Fatal("expected the re-advertised route to survive the sweep")
+	}
+	if r.Best(stale) != nil {
+		t.Fatal("expected the unrefreshed stale route to be swept on End-of-RIB")
+	}
+}
+
+func TestScheduleSweepFiresAfterDelay(t *testing.T) {
+	r := New(nil)
+	p := prefix("10.4.0.0", 24)
+	r.Update("peerA", update([]bgp.Prefix{p}, nil), true, net.ParseIP("1.1.1.1"), false)
+	r.MarkPeerStale("peerA")
+
+	r.ScheduleSweep("peerA", 10*time.Millisecond)
+
+	deadline := time.After(time.Second)
+	for {
+		if r.Best(p) == nil {
+			return
+		}
+		select {
+		case <-deadline:
+			t.Fatal("expected the scheduled sweep to remove the stale route")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
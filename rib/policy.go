@@ -0,0 +1,92 @@
+package rib
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Rule is one entry in a FilterPolicy's rule chain. A zero-valued field
+// imposes no constraint on that dimension, so a Rule matches everything
+// it doesn't explicitly constrain.
+type Rule struct {
+	// Prefixes, if non-empty, restricts the rule to routes whose
+	// network is contained within one of these CIDR blocks.
+	Prefixes []*net.IPNet
+	// ASPath, if set, must match the space-separated decimal AS_PATH
+	// (e.g. "^65001 65002$").
+	ASPath *regexp.Regexp
+	// Communities, if non-empty, requires the route to carry at least
+	// one of these COMMUNITIES values.
+	Communities []uint32
+	// Deny rejects a matching route instead of accepting it.
+	Deny bool
+}
+
+func (rule *Rule) matches(r *Route) bool {
+	if len(rule.Prefixes) > 0 {
+		found := false
+		for _, n := range rule.Prefixes {
+			if n.Contains(r.Prefix.IP) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if rule.ASPath != nil && !rule.ASPath.MatchString(asPathString(r.ASPath)) {
+		return false
+	}
+	if len(rule.Communities) > 0 {
+		found := false
+		routeCommunities := r.Communities()
+		for _, want := range rule.Communities {
+			for _, have := range routeCommunities {
+				if want == have {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func asPathString(path []uint32) string {
+	parts := make([]string, len(path))
+	for i, asn := range path {
+		parts[i] = strconv.FormatUint(uint64(asn), 10)
+	}
+	return strings.Join(parts, " ")
+}
+
+// FilterPolicy is a Policy driven by an ordered list of Rules per
+// direction: the first matching Rule decides whether a route is
+// accepted, and routes matching no rule are accepted by default.
+type FilterPolicy struct {
+	ImportRules []Rule
+	ExportRules []Rule
+}
+
+func (f *FilterPolicy) Import(peer string, r *Route) (*Route, bool) {
+	return nil, evaluate(f.ImportRules, r)
+}
+
+func (f *FilterPolicy) Export(peer string, r *Route) (*Route, bool) {
+	return nil, evaluate(f.ExportRules, r)
+}
+
+func evaluate(rules []Rule, r *Route) bool {
+	for _, rule := range rules {
+		if rule.matches(r) {
+			return !rule.Deny
+		}
+	}
+	return true
+}
@@ -0,0 +1,338 @@
+// Package rib implements a BGP Routing Information Base: per-peer
+// Adj-RIB-In, a single Loc-RIB holding the selected best path per
+// prefix, and per-peer Adj-RIB-Out, following the decision process in
+// RFC 4271, section 9.1.
+package rib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/jrossi/bgp"
+)
+
+// Route is a single path to a Prefix, as received from one peer.
+type Route struct {
+	Prefix    bgp.Prefix
+	Peer      string
+	NextHop   net.IP
+	Origin    uint8
+	ASPath    []uint32
+	LocalPref uint32
+	MED       uint32
+	EBGP      bool
+	RouterID  net.IP
+
+	// Paths holds the raw path attributes the route was built from, so
+	// it can be re-advertised without re-encoding.
+	Paths []bgp.Path
+
+	// Stale marks a route retained across a Graceful Restart (RFC 4724,
+	// section 4): its peer's session is down, but the route is kept in
+	// the Adj-RIB-In, and so still eligible for best-path selection,
+	// until the peer's restart timer expires or it re-advertises.
+	Stale bool
+}
+
+// RouteFromUpdate builds a Route for prefix out of the path attributes
+// carried in u. peer identifies the Adj-RIB-In the route belongs to;
+// ebgp and routerID describe the session prefix was learned over and
+// are used as decision-process tiebreaks. fourOctetASN must reflect
+// whether peer negotiated the 4-octet AS Number capability (RFC 6793),
+// since that determines how wide the ASNs in its AS_PATH attribute are.
+func RouteFromUpdate(peer string, prefix bgp.Prefix, u *bgp.UPDATE, ebgp bool, routerID net.IP, fourOctetASN bool) *Route {
+	r := &Route{Prefix: prefix, Peer: peer, EBGP: ebgp, RouterID: routerID, Paths: u.Paths}
+	for _, p := range u.Paths {
+		switch p.Code {
+		case bgp.Origin:
+			if len(p.Value) == 1 {
+				r.Origin = p.Value[0]
+			}
+		case bgp.NextHop:
+			r.NextHop = net.IP(append([]byte(nil), p.Value...))
+		case bgp.LocalPref:
+			if len(p.Value) == 4 {
+				r.LocalPref = binary.BigEndian.Uint32(p.Value)
+			}
+		case bgp.MultiExitDisc:
+			if len(p.Value) == 4 {
+				r.MED = binary.BigEndian.Uint32(p.Value)
+			}
+		case bgp.CodeASPath:
+			r.ASPath = parseASPath(p, fourOctetASN)
+		}
+	}
+	return r
+}
+
+// Communities returns the route's COMMUNITIES attribute, if any, as the
+// packed 32-bit community values carried in the attribute (RFC 1997).
+func (r *Route) Communities() []uint32 {
+	for _, p := range r.Paths {
+		if p.Code != bgp.Communities {
+			continue
+		}
+		out := make([]uint32, 0, len(p.Value)/4)
+		for i := 0; i+4 <= len(p.Value); i += 4 {
+			out = append(out, binary.BigEndian.Uint32(p.Value[i:i+4]))
+		}
+		return out
+	}
+	return nil
+}
+
+// parseASPath decodes an AS_PATH attribute with bgp.DecodeASPath, using
+// the ASN width fourOctetASN implies, and flattens every AS_SEQUENCE/
+// AS_SET segment into an ordered list of ASNs. A malformed attribute
+// yields a nil path rather than an error, matching the best-effort
+// decoding the rest of RouteFromUpdate does for other attributes.
+func parseASPath(p bgp.Path, fourOctetASN bool) []uint32 {
+	path, err := bgp.DecodeASPath(p, fourOctetASN)
+	if err != nil {
+		return nil
+	}
+	var out []uint32
+	for _, seg := range path {
+		out = append(out, seg.ASNs...)
+	}
+	return out
+}
+
+// Policy decides whether a Route may be accepted into a peer's
+// Adj-RIB-In (Import) or advertised out to a peer's Adj-RIB-Out
+// (Export). Implementations may rewrite the route (e.g. to set
+// LOCAL_PREF) by returning a modified copy.
+type Policy interface {
+	Import(peer string, r *Route) (*Route, bool)
+	Export(peer string, r *Route) (*Route, bool)
+}
+
+// acceptAllPolicy is used when a RIB is created without an explicit
+// Policy.
+type acceptAllPolicy struct{}
+
+func (acceptAllPolicy) Import(string, *Route) (*Route, bool) { return nil, true }
+func (acceptAllPolicy) Export(string, *Route) (*Route, bool) { return nil, true }
+
+// EventType identifies the kind of change a RIBEvent reports.
+type EventType int
+
+const (
+	BestPathAdded EventType = iota
+	BestPathChanged
+	BestPathWithdrawn
+)
+
+// RIBEvent reports a change to the Loc-RIB's best path for a prefix.
+type RIBEvent struct {
+	Type   EventType
+	Prefix bgp.Prefix
+	Best   *Route // nil for BestPathWithdrawn
+}
+
+// RIB is a routing information base for one local router, shared by all
+// of its peering sessions.
+type RIB struct {
+	policy Policy
+
+	mu       sync.Mutex
+	adjIn    map[string]map[string]*Route // prefix key -> peer -> route
+	locRIB   map[string]*Route            // prefix key -> best route
+	watchers []chan RIBEvent
+}
+
+// New returns an empty RIB. A nil policy accepts and exports every
+// route unmodified.
+func New(policy Policy) *RIB {
+	if policy == nil {
+		policy = acceptAllPolicy{}
+	}
+	return &RIB{
+		policy: policy,
+		adjIn:  make(map[string]map[string]*Route),
+		locRIB: make(map[string]*Route),
+	}
+}
+
+// Update applies an UPDATE message's reachable routes, learned from
+// peer, to the Adj-RIB-In, re-runs best-path selection for each
+// affected prefix, and publishes any resulting Loc-RIB changes to
+// watchers. fourOctetASN must reflect whether peer negotiated the
+// 4-octet AS Number capability; see RouteFromUpdate.
+func (r *RIB) Update(peer string, u *bgp.UPDATE, ebgp bool, routerID net.IP, fourOctetASN bool) {
+	for _, prefix := range u.ReachabilityInfo {
+		route := RouteFromUpdate(peer, prefix, u, ebgp, routerID, fourOctetASN)
+		if imported, ok := r.policy.Import(peer, route); ok {
+			if imported != nil {
+				route = imported
+			}
+			r.set(prefix, peer, route)
+		} else {
+			r.unset(prefix, peer)
+		}
+	}
+}
+
+// Withdraw removes peer's routes to prefixes from the Adj-RIB-In and
+// re-runs best-path selection for each.
+func (r *RIB) Withdraw(peer string, prefixes []bgp.Prefix) {
+	for _, prefix := range prefixes {
+		r.unset(prefix, peer)
+	}
+}
+
+func (r *RIB) set(prefix bgp.Prefix, peer string, route *Route) {
+	key := prefixKey(prefix)
+
+	r.mu.Lock()
+	peers, ok := r.adjIn[key]
+	if !ok {
+		peers = make(map[string]*Route)
+		r.adjIn[key] = peers
+	}
+	route.Stale = false
+	peers[peer] = route
+	r.selectBest(prefix, key)
+	r.mu.Unlock()
+}
+
+func (r *RIB) unset(prefix bgp.Prefix, peer string) {
+	key := prefixKey(prefix)
+
+	r.mu.Lock()
+	if peers, ok := r.adjIn[key]; ok {
+		delete(peers, peer)
+		if len(peers) == 0 {
+			delete(r.adjIn, key)
+		}
+	}
+	r.selectBest(prefix, key)
+	r.mu.Unlock()
+}
+
+// selectBest recomputes the best route for key and publishes a
+// RIBEvent if the Loc-RIB changed. Callers must hold r.mu.
+func (r *RIB) selectBest(prefix bgp.Prefix, key string) {
+	var best *Route
+	for _, candidate := range r.adjIn[key] {
+		if best == nil || Better(candidate, best) {
+			best = candidate
+		}
+	}
+
+	old, hadOld := r.locRIB[key]
+	switch {
+	case best == nil && hadOld:
+		delete(r.locRIB, key)
+		r.publish(RIBEvent{Type: BestPathWithdrawn, Prefix: prefix})
+	case best != nil && !hadOld:
+		r.locRIB[key] = best
+		r.publish(RIBEvent{Type: BestPathAdded, Prefix: prefix, Best: best})
+	case best != nil && hadOld && best != old:
+		r.locRIB[key] = best
+		r.publish(RIBEvent{Type: BestPathChanged, Prefix: prefix, Best: best})
+	}
+}
+
+func (r *RIB) publish(ev RIBEvent) {
+	for _, ch := range r.watchers {
+		select {
+		case ch <- ev:
+		default: // slow watcher; drop rather than block the RIB.
+		}
+	}
+}
+
+// Best returns the current Loc-RIB route for prefix, or nil if there is
+// none.
+func (r *RIB) Best(prefix bgp.Prefix) *Route {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.locRIB[prefixKey(prefix)]
+}
+
+// AdjRIBOut builds peer's Adj-RIB-Out: every Loc-RIB route that the
+// policy's Export rule admits for peer.
+func (r *RIB) AdjRIBOut(peer string) []*Route {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]*Route, 0, len(r.locRIB))
+	for _, route := range r.locRIB {
+		exported, ok := r.policy.Export(peer, route)
+		if !ok {
+			continue
+		}
+		if exported != nil {
+			route = exported
+		}
+		out = append(out, route)
+	}
+	return out
+}
+
+// Watch returns a channel of Loc-RIB change notifications. The channel
+// is buffered; slow consumers miss events rather than blocking route
+// processing.
+func (r *RIB) Watch() <-chan RIBEvent {
+	ch := make(chan RIBEvent, 64)
+	r.mu.Lock()
+	r.watchers = append(r.watchers, ch)
+	r.mu.Unlock()
+	return ch
+}
+
+// Better reports whether a should be preferred over b under the RFC
+// 4271, section 9.1.2 decision process: higher LOCAL_PREF, shorter
+// AS_PATH, lower ORIGIN, lower MED (only between routes from the same
+// neighboring AS, per section 9.1.2.2), eBGP over iBGP, then lowest
+// BGP Identifier. IGP metric to NEXT_HOP is not compared: a RIB has no
+// notion of the underlying IGP.
+func Better(a, b *Route) bool {
+	if a.LocalPref != b.LocalPref {
+		return a.LocalPref > b.LocalPref
+	}
+	if len(a.ASPath) != len(b.ASPath) {
+		return len(a.ASPath) < len(b.ASPath)
+	}
+	if a.Origin != b.Origin {
+		return a.Origin < b.Origin
+	}
+	if a.MED != b.MED && sameNeighboringAS(a, b) {
+		return a.MED < b.MED
+	}
+	if a.EBGP != b.EBGP {
+		return a.EBGP
+	}
+	return cmpRouterID(a.RouterID, b.RouterID) < 0
+}
+
+// sameNeighboringAS reports whether a and b were learned from the same
+// first AS in their AS_PATH, the condition RFC 4271 section 9.1.2.2
+// places on comparing MED between two routes.
+func sameNeighboringAS(a, b *Route) bool {
+	if len(a.ASPath) == 0 || len(b.ASPath) == 0 {
+		return len(a.ASPath) == len(b.ASPath)
+	}
+	return a.ASPath[0] == b.ASPath[0]
+}
+
+func cmpRouterID(a, b net.IP) int {
+	a, b = a.To4(), b.To4()
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func prefixKey(p bgp.Prefix) string {
+	bits, _ := p.Mask.Size()
+	return fmt.Sprintf("%d/%s/%d", p.AFI, p.IP.String(), bits)
+}
@@ -0,0 +1,122 @@
+package rib
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/jrossi/bgp"
+)
+
+func localPrefPath(v uint32) bgp.Path {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return bgp.Path{Code: bgp.LocalPref, Value: b}
+}
+
+func prefix(ip string, bits int) bgp.Prefix {
+	return bgp.Prefix{IP: net.ParseIP(ip).To4(), Mask: net.CIDRMask(bits, 32), AFI: bgp.AFIIPv4, SAFI: bgp.SAFIUnicast}
+}
+
+func update(prefixes []bgp.Prefix, paths []bgp.Path) *bgp.UPDATE {
+	return bgp.NewUPDATE(nil, paths, prefixes)
+}
+
+func TestRIBSelectsHigherLocalPref(t *testing.T) {
+	r := New(nil)
+	p := prefix("10.0.0.0", 24)
+
+	r.Update("peerA", update([]bgp.Prefix{p}, []bgp.Path{localPrefPath(100)}), true, net.ParseIP("1.1.1.1"), false)
+	r.Update("peerB", update([]bgp.Prefix{p}, []bgp.Path{localPrefPath(200)}), true, net.ParseIP("2.2.2.2"), false)
+
+	best := r.Best(p)
+	if best == nil || best.Peer != "peerB" {
+		t.Fatalf("got best = %+v, want route from peerB", best)
+	}
+}
+
+func TestRIBWithdraw(t *testing.T) {
+	r := New(nil)
+	p := prefix("10.0.1.0", 24)
+
+	r.Update("peerA", update([]bgp.Prefix{p}, nil), true, net.ParseIP("1.1.1.1"), false)
+	if r.Best(p) == nil {
+		t.Fatal("expected a best route after Update")
+	}
+
+	r.Withdraw("peerA", []bgp.Prefix{p})
+	if r.Best(p) != nil {
+		t.Fatal("expected no best route after Withdraw")
+	}
+}
+
+func TestRIBWatchEmitsEvents(t *testing.T) {
+	r := New(nil)
+	p := prefix("10.0.2.0", 24)
+	ch := r.Watch()
+
+	r.Update("peerA", update([]bgp.Prefix{p}, nil), true, net.ParseIP("1.1.1.1"), false)
+
+	select {
+	case ev := <-ch:
+		if ev.Type != BestPathAdded {
+			t.Fatalf("got event type %v, want BestPathAdded", ev.Type)
+		}
+	default:
+		t.Fatal("expected a RIBEvent on the watch channel")
+	}
+}
+
+func asPath(width int, asns ...uint32) bgp.Path {
+	path := bgp.ASPath{{Type: bgp.ASSequence, ASNs: asns}}
+	if width == 4 {
+		return bgp.NewASPath4Octet(path)
+	}
+	return bgp.NewASPath2Octet(path)
+}
+
+func TestRIBDecodesFourOctetASPath(t *testing.T) {
+	r := New(nil)
+	p := prefix("10.0.4.0", 24)
+
+	r.Update("peerA", update([]bgp.Prefix{p}, []bgp.Path{asPath(4, 400000)}), true, net.ParseIP("1.1.1.1"), true)
+
+	best := r.Best(p)
+	if best == nil || len(best.ASPath) != 1 || best.ASPath[0] != 400000 {
+		t.Fatalf("got ASPath %v, want [400000]", best.ASPath)
+	}
+}
+
+func TestRIBMEDOnlyComparedWithinSameNeighboringAS(t *testing.T) {
+	r := New(nil)
+	p := prefix("10.0.5.0", 24)
+
+	medPath := func(v uint32) bgp.Path {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, v)
+		return bgp.Path{Code: bgp.MultiExitDisc, Value: b}
+	}
+
+	r.Update("peerA", update([]bgp.Prefix{p}, []bgp.Path{asPath(2, 65001), medPath(100)}), true, net.ParseIP("1.1.1.1"), false)
+	r.Update("peerB", update([]bgp.Prefix{p}, []bgp.Path{asPath(2, 65002), medPath(50)}), true, net.ParseIP("9.9.9.9"), false)
+
+	best := r.Best(p)
+	if best == nil || best.Peer != "peerA" {
+		t.Fatalf("got best = %+v, want the peerA route: MED must not be compared across different neighboring ASes", best)
+	}
+}
+
+func TestFilterPolicyDeniesByPrefix(t *testing.T) {
+	_, deniedNet, _ := net.ParseCIDR("10.0.0.0/8")
+	policy := &FilterPolicy{
+		ImportRules: []Rule{{Prefixes: []*net.IPNet{deniedNet}, Deny: true}},
+	}
+	r := New(policy)
+	p := prefix("10.0.3.0", 24)
+
+	r.Update("peerA", update([]bgp.Prefix{p}, nil), true, net.ParseIP("1.1.1.1"), false)
+
+	if r.Best(p) != nil {
+		t.Fatal("expected route to be denied by import policy")
+	}
+}
@@ -33,7 +33,18 @@ type Header struct {
 
 func newHeader(typ int) *Header { return &Header{0, uint8(typ)} }
 
-type Prefix net.IPNet
+// Prefix is a single IP route as carried in the classic (IPv4-only)
+// UPDATE NLRI fields, or as an element of an MP_REACH_NLRI /
+// MP_UNREACH_NLRI attribute (RFC 4760). Prefixes decoded from the plain
+// WithdrawnRoutes/ReachabilityInfo fields always get AFI/SAFI (1, 1)
+// (AFIIPv4, SAFIUnicast), the same as one built by hand for IPv4
+// unicast, so the two compare and hash equal (see rib.prefixKey).
+type Prefix struct {
+	IP   net.IP
+	Mask net.IPMask
+	AFI  uint16
+	SAFI uint8
+}
 
 // Size returns the length of the mask in bits.
 func (p *Prefix) Size() int {
@@ -41,27 +52,49 @@ func (p *Prefix) Size() int {
 	return bits
 }
 
-// len returns the length of prefix in bytes.
-func (p *Prefix) len() int { return 1 + len(p.IP) }
+// len returns the length of prefix in bytes, as it appears on the wire:
+// one length octet followed by the minimum number of octets needed to
+// hold the prefix bits (RFC 4271, section 4.3).
+func (p *Prefix) len() int {
+	bits, _ := p.Mask.Size()
+	return 1 + (bits+7)/8
+}
 
-// Path Flags.
+// Path Flags. See RFC 4271, section 4.3: the attribute flags octet has
+// Optional, Transitive, Partial and Extended Length in its top four
+// bits, with the bottom four bits unused.
 const (
-	FlagOptional   = 1 << 8
-	FlagTransitive = 1 << 7
-	FlagPartial    = 1 << 6
-	FlagLength     = 1 << 5
+	FlagOptional   = 1 << 7
+	FlagTransitive = 1 << 6
+	FlagPartial    = 1 << 5
+	FlagLength     = 1 << 4
 )
 
 // Path Codes.
 const (
 	_ = iota
 	Origin
-	ASPath
+	CodeASPath
 	NextHop
 	MultiExitDisc
 	LocalPref
 	AtomicAggregate
-	Aggregator
+	CodeAggregator
+	Communities // RFC 1997.
+)
+
+// Path Codes defined outside of RFC 4271 itself.
+const (
+	// CodeMPReachNLRI and CodeMPUnreachNLRI carry non-IPv4-unicast
+	// reachability information. See RFC 4760.
+	CodeMPReachNLRI   = 14
+	CodeMPUnreachNLRI = 15
+
+	// CodeAS4Path and CodeAS4Aggregator carry the real 4-octet AS
+	// numbers alongside the 2-octet AS_PATH/AGGREGATOR sent to peers
+	// that haven't negotiated 4-octet ASN support. See RFC 6793.
+	CodeAS4Path       = 17
+	CodeAS4Aggregator = 18
 )
 
 type Path struct {
@@ -85,20 +118,27 @@ type Parameter struct {
 func (p *Parameter) len() int { return 2 + len(p.Value) }
 
 // OPEN holds the information used in the OPEN message format. RFC 4271, Section 4.2.
+//
+// MyAS is the full 4-octet AS number (RFC 6793). Since the wire format
+// only has two octets for it, pack substitutes AS_TRANS (23456) there
+// when MyAS doesn't fit, and NewOPEN always advertises the real value
+// through a 4-octet AS Number capability (code 65) so the peer can
+// recover it; see unpack and ReconcileASPath.
 type OPEN struct {
 	*Header
 	Version       uint8
-	MyAS          uint16
+	MyAS          uint32
 	HoldTime      uint16
 	BGPIdentifier net.IP // Must always be a 4 bytes.
 	Parameters    []Parameter
 }
 
-// NewOPEN returns an initialized OPEN message.
-func NewOPEN(MyAS, HoldTime uint16, BGPIdentifier net.IP, Parameters []Parameter) *OPEN {
-
+// NewOPEN returns an initialized OPEN message, always advertising the
+// 4-octet AS Number capability for MyAS alongside Parameters.
+func NewOPEN(MyAS uint32, HoldTime uint16, BGPIdentifier net.IP, Parameters []Parameter) *OPEN {
+	params := append(append([]Parameter{}, Parameters...), NewASNCapabilityParameter(MyAS))
 	return &OPEN{Header: newHeader(typeOpen), Version: Version, MyAS: MyAS,
-		HoldTime: HoldTime, BGPIdentifier: BGPIdentifier.To4(), Parameters: Parameters}
+		HoldTime: HoldTime, BGPIdentifier: BGPIdentifier.To4(), Parameters: params}
 }
 
 // Len returns the length of the entire OPEN message.